@@ -0,0 +1,186 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*           ACCESS LOG           */
+/**********************************/
+
+// AccessLogFormat is the format of access log lines
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat is the Apache Common Log Format followed by
+	// the quoted route name, the latency in microseconds and the quoted request ID
+	CommonLogFormat AccessLogFormat = iota
+	// CombinedLogFormat is the Apache Combined Log Format followed by
+	// the quoted route name, the latency in microseconds and the quoted request ID
+	CombinedLogFormat
+	// JSONLogFormat writes structured JSON lines with log/slog
+	JSONLogFormat
+)
+
+// AccessLogger is a middleware that logs requests. Log lines hold the name of
+// the endpoint of the request, see Context.Endpoint, and the request ID validated by
+// RequestIDMiddleware, the X-Request-ID header of requests is never logged as is.
+//
+// Example:
+//
+//    app.Use("/", micro.NewAccessLogger(os.Stdout, micro.CombinedLogFormat).Handler)
+type AccessLogger struct {
+	// Output is where log lines are written
+	Output io.Writer
+	Format AccessLogFormat
+	// SampleRate is the fraction of requests logged, between 0 and 1, 0 logs every request.
+	// Requests with a status greater than 499 are always logged.
+	SampleRate float64
+	// TrustedProxies are the proxies whose X-Forwarded-For header is used to find the client address
+	TrustedProxies TrustedProxies
+	mutex          sync.Mutex
+	logger         *slog.Logger
+}
+
+// NewAccessLogger returns a new AccessLogger logging every request
+func NewAccessLogger(output io.Writer, format AccessLogFormat) *AccessLogger {
+	return &AccessLogger{Output: output, Format: format, SampleRate: 1}
+}
+
+// Handler logs the request once the next handlers returned or panicked
+func (logger *AccessLogger) Handler(ctx *Context, rw *ResponseWriterWithCode, next Next) {
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			logger.log(ctx, http.StatusInternalServerError, rw.Length(), time.Since(start))
+			panic(err)
+		}
+		logger.log(ctx, rw.Code(), rw.Length(), time.Since(start))
+	}()
+	next()
+}
+
+func (logger *AccessLogger) log(ctx *Context, status int, length int, latency time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 500 && logger.SampleRate > 0 && logger.SampleRate < 1 && rand.Float64() >= logger.SampleRate {
+		return
+	}
+	var (
+		request   = ctx.Request
+		routeName string
+		requestID = ctx.RequestID()
+		client    = logger.TrustedProxies.ClientIP(request)
+	)
+	if endpoint := ctx.Endpoint(); endpoint != nil {
+		routeName = endpoint.Name()
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.Format == JSONLogFormat {
+		if logger.logger == nil {
+			logger.logger = slog.New(slog.NewJSONHandler(logger.Output, nil))
+		}
+		logger.logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("remote_addr", client),
+			slog.String("method", request.Method),
+			slog.String("uri", request.RequestURI),
+			slog.String("proto", request.Proto),
+			slog.Int("status", status),
+			slog.Int("bytes", length),
+			slog.String("route", routeName),
+			slog.Duration("latency", latency),
+			slog.String("request_id", requestID),
+			slog.String("referer", request.Referer()),
+			slog.String("user_agent", request.UserAgent()),
+		)
+		return
+	}
+	user := "-"
+	if name, _, ok := request.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if length > 0 {
+		size = fmt.Sprint(length)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s", client, user, time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		request.Method, request.RequestURI, request.Proto, status, size)
+	if logger.Format == CombinedLogFormat {
+		line = line + fmt.Sprintf(" %q %q", request.Referer(), request.UserAgent())
+	}
+	fmt.Fprintf(logger.Output, "%s %q %d %q\n", line, routeName, latency.Microseconds(), requestID)
+}
+
+/**********************************/
+/*         TRUSTED PROXIES        */
+/**********************************/
+
+// TrustedProxies are networks of proxies trusted to set the X-Forwarded-For header
+type TrustedProxies []*net.IPNet
+
+// NewTrustedProxies returns TrustedProxies given CIDRs or IP addresses
+func NewTrustedProxies(addresses ...string) (TrustedProxies, error) {
+	proxies := TrustedProxies{}
+	for _, address := range addresses {
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				address = address + "/32"
+			} else {
+				address = address + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains returns true if ip belongs to a trusted proxy network
+func (proxies TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client. If the request comes from a trusted proxy,
+// the X-Forwarded-For header is read from right to left and the first address
+// that is not a trusted proxy is returned.
+func (proxies TrustedProxies) ClientIP(request *http.Request) string {
+	client, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		client = request.RemoteAddr
+	}
+	if ip := net.ParseIP(client); ip == nil || !proxies.Contains(ip) {
+		return client
+	}
+	forwarded := strings.Split(strings.Join(request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(address)
+		if ip == nil {
+			break
+		}
+		client = address
+		if !proxies.Contains(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,274 @@
+package micro
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+/**********************************/
+/*         AUTHENTICATION         */
+/**********************************/
+
+// Principal is an authenticated client, authentication middlewares
+// register it in the request injector as a *Principal.
+type Principal struct {
+	Subject string
+	Roles   []string
+	Scopes  []string
+	// Claims are the claims of a JWT or any data set by a validator
+	Claims map[string]interface{}
+}
+
+// HasRole returns true if the principal has the role
+func (principal *Principal) HasRole(role string) bool {
+	return containsString(principal.Roles, role)
+}
+
+// HasScope returns true if the principal has the scope
+func (principal *Principal) HasScope(scope string) bool {
+	return containsString(principal.Scopes, scope)
+}
+
+// unauthorized sets the WWW-Authenticate header and the 401 status handled by Micro.Error
+func unauthorized(rw http.ResponseWriter, challenge string, next Next) {
+	rw.Header().Set("WWW-Authenticate", challenge)
+	rw.WriteHeader(http.StatusUnauthorized)
+	next()
+}
+
+// BasicAuth is a middleware authenticating requests with HTTP Basic authentication
+//
+// Example:
+//
+//    app.Use("/admin", micro.NewBasicAuth("admin", map[string]string{"bob": "secret"}).Handler)
+//    app.DeclareRequestService((*micro.Principal)(nil))
+type BasicAuth struct {
+	Realm string
+	// Validate returns the principal matching the credentials or nil
+	Validate func(username, password string) *Principal
+}
+
+// NewBasicAuth returns a BasicAuth validating credentials against a map of usernames to passwords,
+// passwords are compared in constant time.
+func NewBasicAuth(realm string, users map[string]string) *BasicAuth {
+	hashes := map[string][32]byte{}
+	for username, password := range users {
+		hashes[username] = sha256.Sum256([]byte(password))
+	}
+	return &BasicAuth{
+		Realm: realm,
+		Validate: func(username, password string) *Principal {
+			// always compare so the response time does not tell if the user exists
+			expected, found := hashes[username]
+			actual := sha256.Sum256([]byte(password))
+			if subtle.ConstantTimeCompare(expected[:], actual[:]) == 1 && found {
+				return &Principal{Subject: username}
+			}
+			return nil
+		},
+	}
+}
+
+// Handler authenticates the request or sets the 401 status
+func (auth *BasicAuth) Handler(r *http.Request, rw http.ResponseWriter, injector *Injector, next Next) {
+	if username, password, ok := r.BasicAuth(); ok {
+		if principal := auth.Validate(username, password); principal != nil {
+			injector.Register(principal)
+			next()
+			return
+		}
+	}
+	unauthorized(rw, fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, auth.Realm), next)
+}
+
+// BearerAuth is a middleware authenticating requests with a bearer token
+// in the Authorization header
+type BearerAuth struct {
+	Realm string
+	// Validate returns the principal matching the token or an error
+	Validate func(token string) (*Principal, error)
+}
+
+// NewBearerAuth returns a new BearerAuth
+func NewBearerAuth(realm string, validate func(token string) (*Principal, error)) *BearerAuth {
+	return &BearerAuth{Realm: realm, Validate: validate}
+}
+
+// Handler authenticates the request or sets the 401 status
+func (auth *BearerAuth) Handler(r *http.Request, rw http.ResponseWriter, injector *Injector, next Next) {
+	authenticate(auth.Realm, auth.Validate, r, rw, injector, next)
+}
+
+// authenticate validates the bearer token of a request with validate
+func authenticate(realm string, validate func(string) (*Principal, error), r *http.Request, rw http.ResponseWriter, injector *Injector, next Next) {
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	token, ok := bearerToken(r)
+	if !ok {
+		unauthorized(rw, challenge, next)
+		return
+	}
+	principal, err := validate(token)
+	if err != nil || principal == nil {
+		description := "invalid token"
+		if err != nil {
+			description = err.Error()
+		}
+		unauthorized(rw, fmt.Sprintf(`%s, error="invalid_token", error_description=%q`, challenge, description), next)
+		return
+	}
+	injector.Register(principal)
+	next()
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+/**********************************/
+/*          AUTHORIZATION         */
+/**********************************/
+
+// Route attributes read by Authorizer
+const (
+	// RolesAttribute is a string or a []string, the principal must have one of the roles
+	RolesAttribute = "roles"
+	// ScopesAttribute is a string or a []string, the principal must have all the scopes
+	ScopesAttribute = "scopes"
+	// PolicyAttribute is a Policy, or a func(*Principal, *Context) bool, the principal must satisfy
+	PolicyAttribute = "policy"
+)
+
+// Policy returns true if the principal is allowed to access the endpoint of the request
+type Policy func(principal *Principal, ctx *Context) bool
+
+// Authorizer is a middleware checking that the principal registered by an authentication
+// middleware is allowed to access the endpoint of the request, given the endpoint
+// RolesAttribute, ScopesAttribute and PolicyAttribute attributes.
+// It sets the 403 status if the principal is not allowed. If the endpoint has access rules
+// and there is no principal, it sets the 401 status with the Challenge as WWW-Authenticate
+// header, or the 403 status if there is no Challenge.
+// Access is denied with the 500 status if an attribute has another type, Boot reports such attributes.
+//
+// Example:
+//
+//    admin := micro.NewControllerCollection().SetAttribute(micro.RolesAttribute, []string{"admin"})
+//    app.Use("/", auth.Handler)
+//    app.Use("/", micro.NewAuthorizer().Handler)
+//    app.Mount("/admin", admin)
+type Authorizer struct {
+	// Challenge is the WWW-Authenticate header of requests without principal,
+	// such as Bearer realm="api"
+	Challenge string
+}
+
+// NewAuthorizer returns a new Authorizer without Challenge
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{}
+}
+
+// Handler checks the access rules of the endpoint then calls the next handler
+func (authorizer *Authorizer) Handler(ctx *Context, rw http.ResponseWriter, injector *Injector, next Next) {
+	endpoint := ctx.Endpoint()
+	if endpoint == nil {
+		next()
+		return
+	}
+	roles, scopes, policy, err := accessRules(endpoint)
+	if err != nil {
+		log.Printf("route %s : %s", endpoint.Name(), err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		next()
+		return
+	}
+	if len(roles) == 0 && len(scopes) == 0 && policy == nil {
+		next()
+		return
+	}
+	service, err := injector.Resolve(reflect.TypeOf((*Principal)(nil)))
+	if err != nil {
+		if authorizer.Challenge != "" {
+			unauthorized(rw, authorizer.Challenge, next)
+			return
+		}
+		rw.WriteHeader(http.StatusForbidden)
+		next()
+		return
+	}
+	if !Authorize(service.(*Principal), ctx, roles, scopes, policy) {
+		rw.WriteHeader(http.StatusForbidden)
+	}
+	next()
+}
+
+// Authorize returns true if the principal has one of the roles, all the scopes
+// and satisfies the policy. Empty roles, scopes and a nil policy are not checked.
+func Authorize(principal *Principal, ctx *Context, roles []string, scopes []string, policy Policy) bool {
+	if len(roles) > 0 {
+		allowed := false
+		for _, role := range roles {
+			allowed = allowed || principal.HasRole(role)
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, scope := range scopes {
+		if !principal.HasScope(scope) {
+			return false
+		}
+	}
+	return policy == nil || policy(principal, ctx)
+}
+
+// accessRules returns the access rules of a route, or an error if an attribute has an unexpected type
+func accessRules(route *Route) (roles []string, scopes []string, policy Policy, err error) {
+	if roles, err = stringsAttribute(route, RolesAttribute); err != nil {
+		return nil, nil, nil, err
+	}
+	if scopes, err = stringsAttribute(route, ScopesAttribute); err != nil {
+		return nil, nil, nil, err
+	}
+	switch v := route.Attribute(PolicyAttribute).(type) {
+	case nil:
+	case Policy:
+		policy = v
+	case func(*Principal, *Context) bool:
+		policy = v
+	default:
+		return nil, nil, nil, fmt.Errorf("attribute %s is a %T, expected a micro.Policy", PolicyAttribute, v)
+	}
+	return roles, scopes, policy, nil
+}
+
+// stringsAttribute converts a string or a []string attribute to a slice
+func stringsAttribute(route *Route, name string) ([]string, error) {
+	switch v := route.Attribute(name).(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("attribute %s is a %T, expected a string or a []string", name, v)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
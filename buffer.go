@@ -0,0 +1,129 @@
+package micro
+
+import (
+	"bytes"
+	"net/http"
+)
+
+/**********************************/
+/*        BUFFERED RESPONSES      */
+/**********************************/
+
+// responseBuffer holds the status and the body of a response until it is committed
+// to the target ResponseWriter, headers are set on the header of the target unless
+// the buffer has its own header.
+// The response is committed once the body is larger than threshold, unless threshold is 0,
+// or when a handler flushes it.
+type responseBuffer struct {
+	target    http.ResponseWriter
+	header    http.Header
+	threshold int
+	code      int
+	body      bytes.Buffer
+	committed bool
+}
+
+func newResponseBuffer(target http.ResponseWriter, threshold int) *responseBuffer {
+	return &responseBuffer{target: target, threshold: threshold}
+}
+
+// Header returns the header of the buffer, or the header of the target
+func (buffer *responseBuffer) Header() http.Header {
+	if buffer.header != nil {
+		return buffer.header
+	}
+	return buffer.target.Header()
+}
+
+// WriteHeader records the status code
+func (buffer *responseBuffer) WriteHeader(code int) {
+	if buffer.committed {
+		return
+	}
+	if buffer.code == 0 {
+		buffer.code = code
+	}
+}
+
+// Write buffers the body, or writes it to the target once the response is committed
+func (buffer *responseBuffer) Write(b []byte) (int, error) {
+	if buffer.committed {
+		return buffer.target.Write(b)
+	}
+	if buffer.code == 0 {
+		buffer.code = http.StatusOK
+	}
+	n, err := buffer.body.Write(b)
+	if buffer.threshold > 0 && buffer.body.Len() > buffer.threshold {
+		return n, buffer.commit()
+	}
+	return n, err
+}
+
+// Flush commits the response and flushes the target, so handlers can stream responses
+func (buffer *responseBuffer) Flush() {
+	buffer.commit()
+	if flusher, ok := buffer.target.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the target, so http.ResponseController can reach it
+func (buffer *responseBuffer) Unwrap() http.ResponseWriter {
+	return buffer.target
+}
+
+// Status returns the status code, 200 if none was written
+func (buffer *responseBuffer) Status() int {
+	if buffer.code == 0 {
+		return http.StatusOK
+	}
+	return buffer.code
+}
+
+// commit writes the buffered response to the target, later writes are not buffered
+func (buffer *responseBuffer) commit() error {
+	if buffer.committed {
+		return nil
+	}
+	buffer.committed = true
+	for name, values := range buffer.header {
+		buffer.target.Header()[name] = values
+	}
+	if buffer.code != 0 {
+		buffer.target.WriteHeader(buffer.code)
+	}
+	_, err := buffer.body.WriteTo(buffer.target)
+	return err
+}
+
+// discard removes the status and the body of the response if it has not been committed
+func (buffer *responseBuffer) discard() bool {
+	if buffer.committed {
+		return false
+	}
+	buffer.code = 0
+	buffer.body.Reset()
+	return true
+}
+
+// discarder is a buffering ResponseWriter, such as a responseBuffer or a timeoutWriter
+type discarder interface {
+	discard() bool
+}
+
+// discard removes the status and the body written so far if the response
+// is buffered and has not been committed, so an error handler can replace it.
+// Headers describing the body are removed, other headers such as cookies are kept.
+func (r *ResponseWriterWithCode) discard() bool {
+	buffer, ok := r.ResponseWriter.(discarder)
+	if !ok || !buffer.discard() {
+		return false
+	}
+	r.code, r.writtenLength = 0, 0
+	header := r.Header()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "ETag"} {
+		header.Del(name)
+	}
+	return true
+}
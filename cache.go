@@ -0,0 +1,331 @@
+package micro
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*         RESPONSE CACHE         */
+/**********************************/
+
+// CachePolicyAttribute is the route attribute holding the CachePolicy of a route,
+// it replaces the default policy of the HTTPCache
+const CachePolicyAttribute = "cache.policy"
+
+// CachePolicy is the way responses of a route are cached
+type CachePolicy struct {
+	// Disabled disables ETags and caching
+	Disabled bool
+	// TTL is the duration responses are stored in the cache, 0 only adds ETags
+	TTL time.Duration
+	// WeakETag generates weak ETags instead of strong ones
+	WeakETag bool
+	// Vary are the request headers responses depend on, in addition to the Vary response header
+	Vary []string
+	// CacheControl is the Cache-Control header of responses, unless handlers set it
+	CacheControl string
+}
+
+// CachedResponse is a response stored in a ResponseCache
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Stored time.Time
+	// Vary are the request headers of the Vary response headers, set on the entries
+	// indexing the variants of a request
+	Vary []string
+}
+
+// ResponseCache stores responses
+type ResponseCache interface {
+	// Get returns the response stored for key or nil
+	Get(key string) *CachedResponse
+	// Set stores a response for ttl
+	Set(key string, response *CachedResponse, ttl time.Duration)
+}
+
+// HTTPCache is a middleware that adds ETags to successful GET and HEAD responses,
+// answers conditional requests with 304 Not Modified and stores responses
+// in a ResponseCache when the policy has a TTL. Responses are buffered
+// until the next handlers return.
+// Responses setting cookies or with a no-store or private Cache-Control are not stored,
+// nor are responses to requests with an Authorization header unless their Cache-Control
+// is public, s-maxage or must-revalidate, nor responses to requests with cookies unless their
+// Cache-Control is public. The Vary headers of responses are stored in the ResponseCache
+// along with the responses, so they are evicted with them.
+//
+// A cached response is served without calling the next handlers, so the HTTPCache
+// must be the last middleware of the routes it caches, after the authentication,
+// authorization and rate limiting middlewares. Responses are neither stored nor served
+// from the cache when another middleware runs after it, only ETags are added.
+//
+// Example:
+//
+//    app.Use("/", micro.NewHTTPCache(micro.NewLRUCache(1000)).Handler)
+//    app.Get("/movies", movies).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute})
+type HTTPCache struct {
+	Cache ResponseCache
+	// Policy is the policy of routes without a CachePolicyAttribute attribute
+	Policy CachePolicy
+}
+
+// NewHTTPCache returns an HTTPCache adding strong ETags without storing responses by default
+func NewHTTPCache(cache ResponseCache) *HTTPCache {
+	return &HTTPCache{Cache: cache}
+}
+
+// Handler serves cached responses or caches the response of the next handlers
+func (cache *HTTPCache) Handler(ctx *Context, rw *ResponseWriterWithCode, next Next) {
+	request, policy := ctx.Request, cache.Policy
+	if endpoint := ctx.Endpoint(); endpoint != nil {
+		if routePolicy, ok := endpoint.Attribute(CachePolicyAttribute).(CachePolicy); ok {
+			policy = routePolicy
+		}
+	}
+	if policy.Disabled || (request.Method != "GET" && request.Method != "HEAD") {
+		next()
+		return
+	}
+	primaryKey := request.Method + " " + request.Host + request.URL.RequestURI()
+	if middlewareAfter(ctx) {
+		policy.TTL = 0
+	}
+	if policy.TTL > 0 && cache.Cache != nil {
+		if cached := cache.Cache.Get(cache.key(primaryKey, request)); cached != nil {
+			cache.serve(rw, request, cached)
+			return
+		}
+	}
+	buffer := newResponseBuffer(rw.ResponseWriter, 0)
+	original := rw.ResponseWriter
+	// headers set by previous handlers, such as a request ID, are not stored
+	previousHeader := original.Header().Clone()
+	rw.ResponseWriter = buffer
+	func() {
+		// the original writer must be restored if next panics
+		defer func() { rw.ResponseWriter = original }()
+		next()
+	}()
+	status := buffer.Status()
+	header := original.Header()
+	// a flushed response is streamed and cannot be cached
+	if status != http.StatusOK || buffer.committed {
+		buffer.commit()
+		return
+	}
+	if header.Get("ETag") == "" {
+		header.Set("ETag", computeETag(buffer.body.Bytes(), policy.WeakETag))
+	}
+	if header.Get("Cache-Control") == "" && policy.CacheControl != "" {
+		header.Set("Cache-Control", policy.CacheControl)
+	}
+	if policy.TTL > 0 && cache.Cache != nil && storable(request, header) {
+		stored := http.Header{}
+		for name, values := range header {
+			if strings.Join(values, "\n") != strings.Join(previousHeader[name], "\n") {
+				stored[name] = append([]string(nil), values...)
+			}
+		}
+		cache.Cache.Set(cache.store(primaryKey, request, header, policy), &CachedResponse{
+			Status: status,
+			Header: stored,
+			Body:   append([]byte(nil), buffer.body.Bytes()...),
+			Stored: time.Now(),
+		}, policy.TTL)
+	}
+	if notModified(request, header) {
+		header.Del("Content-Length")
+		original.WriteHeader(http.StatusNotModified)
+		rw.code, rw.writtenLength = http.StatusNotModified, 0
+		return
+	}
+	buffer.commit()
+}
+
+// serve writes a cached response
+func (cache *HTTPCache) serve(rw *ResponseWriterWithCode, request *http.Request, cached *CachedResponse) {
+	header := rw.Header()
+	for name, values := range cached.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(cached.Stored).Seconds())))
+	if notModified(request, header) {
+		header.Del("Content-Length")
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.WriteHeader(cached.Status)
+	rw.Write(cached.Body)
+}
+
+// key returns the cache key of a request given the Vary headers of its previous response
+func (cache *HTTPCache) key(primaryKey string, request *http.Request) string {
+	vary := []string{}
+	if index := cache.Cache.Get(varyKey(primaryKey)); index != nil {
+		vary = index.Vary
+	}
+	return variantKey(primaryKey, vary, request)
+}
+
+// varyKey is the key of the entry holding the Vary headers of the responses to a request
+func varyKey(primaryKey string) string {
+	return "vary\n" + primaryKey
+}
+
+func variantKey(primaryKey string, vary []string, request *http.Request) string {
+	key := primaryKey
+	for _, name := range vary {
+		key += "\n" + name + ":" + strings.Join(request.Header.Values(name), ",")
+	}
+	return key
+}
+
+// store records the Vary headers of a response and of the policy, then returns its cache key
+func (cache *HTTPCache) store(primaryKey string, request *http.Request, header http.Header, policy CachePolicy) string {
+	vary := []string{}
+	for _, value := range append(append([]string{}, policy.Vary...), header.Values("Vary")...) {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !containsString(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+	// responses without Vary headers need no index, unless it replaces a previous one
+	if len(vary) > 0 || cache.Cache.Get(varyKey(primaryKey)) != nil {
+		cache.Cache.Set(varyKey(primaryKey), &CachedResponse{Vary: vary, Stored: time.Now()}, policy.TTL)
+	}
+	return variantKey(primaryKey, vary, request)
+}
+
+func storable(request *http.Request, header http.Header) bool {
+	if header.Get("Set-Cookie") != "" || strings.Contains(header.Get("Vary"), "*") {
+		return false
+	}
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return false
+	}
+	// responses to authenticated requests are shared only when explicitly allowed, RFC 9111 section 3.5
+	if request.Header.Get("Authorization") != "" {
+		return strings.Contains(cacheControl, "public") || strings.Contains(cacheControl, "s-maxage") ||
+			strings.Contains(cacheControl, "must-revalidate")
+	}
+	// responses to requests with cookies may depend on a session
+	if request.Header.Get("Cookie") != "" {
+		return strings.Contains(cacheControl, "public")
+	}
+	return true
+}
+
+// middlewareAfter returns true if a passthrough route runs after the route of ctx
+func middlewareAfter(ctx *Context) bool {
+	for i, route := range ctx.matches {
+		if route == ctx.route {
+			return i+1 < len(ctx.matches) && ctx.matches[i+1].IsPassthrough()
+		}
+	}
+	return false
+}
+
+// notModified returns true if the conditional headers of the request match the response.
+// If-Modified-Since is ignored when If-None-Match is present.
+func notModified(request *http.Request, header http.Header) bool {
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
+
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// LRUCache is a ResponseCache stored in memory, the least recently used
+// responses are removed when the cache is full
+type LRUCache struct {
+	capacity int
+	mutex    sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key      string
+	response *CachedResponse
+	expires  time.Time
+}
+
+// NewLRUCache returns an LRUCache storing at most capacity responses
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{capacity: capacity, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns the response stored for key or nil
+func (cache *LRUCache) Get(key string) *CachedResponse {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+		return nil
+	}
+	cache.order.MoveToFront(element)
+	return entry.response
+}
+
+// Set stores a response for ttl
+func (cache *LRUCache) Set(key string, response *CachedResponse, ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry := &lruEntry{key: key, response: response, expires: time.Now().Add(ttl)}
+	if element, ok := cache.entries[key]; ok {
+		element.Value = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of responses stored
+func (cache *LRUCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.order.Len()
+}
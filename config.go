@@ -0,0 +1,612 @@
+package micro
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*          CONFIGURATION         */
+/**********************************/
+
+// EventConfigChanged is emitted when a reload changed configuration values, with a ConfigChangedEvent
+const EventConfigChanged = "config.changed"
+
+// ConfigChangedEvent is the payload of EventConfigChanged
+type ConfigChangedEvent struct {
+	Config *Config
+	// Keys are the changed keys of JSON and TOML files and the changed variables of env files
+	Keys []string
+}
+
+// Config merges configuration values from several sources and decodes them into structs.
+// Keys are lower case and namespaced with dots, such as "server.port".
+// Sources, from the lowest to the highest precedence, are :
+//
+//   - defaults set with SetDefault and default struct tags
+//   - JSON and TOML files, nested objects and tables are namespaced with dots
+//   - env files, "server.port" is read from the variable EnvPrefix+"SERVER_PORT"
+//   - environment variables, with the same names as in env files
+//   - Args, in the --server.port=8080 form, --debug sets debug to true
+//
+// Files are read in order, later files override earlier ones. Their format depends
+// on their extension : .json, .toml or .env . Files that do not exist are skipped.
+// Lists are comma separated.
+//
+// Example:
+//
+//    type Settings struct {
+//        Server struct {
+//            Port    int           `config:"port" default:"8080" validate:"min=1,max=65535"`
+//            Timeout time.Duration `config:"timeout" default:"30s"`
+//        } `config:"server"`
+//        Database string `config:"database_url" validate:"required"`
+//    }
+//
+//    config := app.Config("config.toml", ".env")
+//    config.EnvPrefix, config.Args = "MOVIES_", os.Args[1:]
+//    settings := Settings{}
+//    if err := config.Load(); err != nil {
+//        log.Fatal(err)
+//    }
+//    if err := config.Decode(&settings); err != nil {
+//        log.Fatal(err)
+//    }
+type Config struct {
+	Files     []string
+	EnvPrefix string
+	Args      []string
+	// Emitter receives EventConfigChanged if not nil
+	Emitter *EventEmitter
+
+	mutex    sync.RWMutex
+	loaded   bool
+	defaults map[string]string
+	files    map[string]string
+	envFiles map[string]string
+	flags    map[string]string
+}
+
+// NewConfig returns a new Config reading files
+func NewConfig(files ...string) *Config {
+	return &Config{
+		Files:    files,
+		defaults: map[string]string{},
+		files:    map[string]string{},
+		envFiles: map[string]string{},
+		flags:    map[string]string{},
+	}
+}
+
+// Config returns a Config reading files, registered in the injector of the application
+// and emitting EventConfigChanged on the application. Values are available once Load is called.
+func (e *Micro) Config(files ...string) *Config {
+	config := NewConfig(files...)
+	config.Emitter = e.EventEmitter
+	e.injector.Register(config)
+	return config
+}
+
+// SetDefault sets the default value of key, slices are converted to lists
+func (c *Config) SetDefault(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.defaults[strings.ToLower(key)] = formatConfigValue(reflect.ValueOf(value))
+}
+
+// Load reads the files and the arguments. When values changed since the
+// previous load, EventConfigChanged is emitted. Values are kept if an error occurs.
+func (c *Config) Load() error {
+	files, envFiles := map[string]string{}, map[string]string{}
+	errs := []error{}
+	for _, file := range c.Files {
+		content, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".json":
+			err = parseJSONConfig(content, files)
+		case ".toml":
+			err = parseTOMLConfig(string(content), files)
+		case ".env":
+			err = parseEnvConfig(string(content), envFiles)
+		default:
+			err = errors.New("unsupported format")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s : %w", file, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	c.mutex.Lock()
+	keys := append(changedConfigKeys(c.files, files), changedConfigKeys(c.envFiles, envFiles)...)
+	changed := c.loaded && len(keys) > 0
+	c.files, c.envFiles, c.flags, c.loaded = files, envFiles, parseConfigArgs(c.Args), true
+	c.mutex.Unlock()
+	if changed && c.Emitter != nil {
+		sort.Strings(keys)
+		c.Emitter.Emit(EventConfigChanged, ConfigChangedEvent{Config: c, Keys: keys})
+	}
+	return nil
+}
+
+// Watch checks the files for changes every interval and loads them when they changed.
+// Errors are logged. It returns a function that stops watching.
+func (c *Config) Watch(interval time.Duration) (stop func()) {
+	done, stamp := make(chan struct{}), c.stamp()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if current := c.stamp(); current != stamp {
+					stamp = current
+					if err := c.Load(); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// stamp returns the modification times and the sizes of the files
+func (c *Config) stamp() string {
+	stamp := ""
+	for _, file := range c.Files {
+		if info, err := os.Stat(file); err == nil {
+			stamp += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+		} else {
+			stamp += "-;"
+		}
+	}
+	return stamp
+}
+
+// Get returns the value of key and whether it is set
+func (c *Config) Get(key string) (string, bool) {
+	key = strings.ToLower(key)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if value, ok := c.flags[key]; ok {
+		return value, true
+	}
+	name := c.EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if value, ok := c.envFiles[name]; ok {
+		return value, true
+	}
+	if value, ok := c.files[key]; ok {
+		return value, true
+	}
+	value, ok := c.defaults[key]
+	return value, ok
+}
+
+// String returns the value of key or an empty string
+func (c *Config) String(key string) string {
+	value, _ := c.Get(key)
+	return value
+}
+
+// Decode sets the fields of the struct v points to from the values of the configuration, then validates them.
+// Fields are read from the key of their config tag, or from their lower case name, nested structs
+// prefix the keys of their fields with their own key. A field tagged config:"-" is ignored.
+// Fields whose key is not set get the value of their default tag, or keep their value.
+//
+// The validate tag holds comma separated rules :
+//
+//   - required : the value is not the zero value
+//   - min=n and max=n : bounds of numbers, durations, or of the length of strings and slices
+//   - oneof=a b c : the value is one of the space separated values
+//
+// Then the Validate() error method of v is called if it exists.
+// Decode returns an error listing every invalid field.
+// Supported types are strings, booleans, numbers, time.Duration, encoding.TextUnmarshaler and slices of them.
+func (c *Config) Decode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config : Decode expects a pointer to a struct, got %T", v)
+	}
+	errs := c.decodeStruct(value.Elem(), "")
+	if validator, ok := v.(interface{ Validate() error }); ok && len(errs) == 0 {
+		if err := validator.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) decodeStruct(value reflect.Value, prefix string) (errs []error) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, tagged := field.Tag.Lookup("config")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if !tagged {
+			name = strings.ToLower(field.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		fieldValue := value.Field(i)
+		if _, ok := fieldValue.Addr().Interface().(encoding.TextUnmarshaler); !ok && field.Type.Kind() == reflect.Struct {
+			errs = append(errs, c.decodeStruct(fieldValue, key)...)
+			continue
+		}
+		raw, ok := c.Get(key)
+		if !ok {
+			raw, ok = field.Tag.Lookup("default")
+		}
+		if ok {
+			if err := setConfigValue(fieldValue, raw); err != nil {
+				errs = append(errs, fmt.Errorf("config %s : %w", key, err))
+				continue
+			}
+		}
+		if err := validateConfigValue(fieldValue, field.Tag.Get("validate")); err != nil {
+			errs = append(errs, fmt.Errorf("config %s : %w", key, err))
+		}
+	}
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setConfigValue parses raw into value
+func setConfigValue(value reflect.Value, raw string) error {
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Type() == durationType {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return err
+			}
+			value.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(n)
+	case reflect.Slice:
+		items := []string{}
+		if strings.TrimSpace(raw) != "" {
+			items = strings.Split(raw, ",")
+		}
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// validateConfigValue checks value against the rules of a validate tag
+func validateConfigValue(value reflect.Value, rules string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "":
+		case "required":
+			if value.IsZero() {
+				return errors.New("is required")
+			}
+		case "min", "max":
+			actual, ok := configMeasure(value)
+			if !ok {
+				return fmt.Errorf("%s does not apply to %s", name, value.Type())
+			}
+			bound, err := strconv.ParseFloat(argument, 64)
+			if value.Type() == durationType {
+				var d time.Duration
+				d, err = time.ParseDuration(argument)
+				bound = float64(d)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid %s rule %s", name, argument)
+			}
+			if name == "min" && actual < bound {
+				return fmt.Errorf("must be at least %s", argument)
+			}
+			if name == "max" && actual > bound {
+				return fmt.Errorf("must be at most %s", argument)
+			}
+		case "oneof":
+			actual := fmt.Sprint(value.Interface())
+			found := false
+			for _, allowed := range strings.Fields(argument) {
+				found = found || allowed == actual
+			}
+			if !found {
+				return fmt.Errorf("must be one of %s", argument)
+			}
+		default:
+			return fmt.Errorf("unknown rule %s", name)
+		}
+	}
+	return nil
+}
+
+// configMeasure returns the number compared by min and max rules
+func configMeasure(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(value.Len()), true
+	}
+	return 0, false
+}
+
+// formatConfigValue converts a default value to its configuration value
+func formatConfigValue(value reflect.Value) string {
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		items := []string{}
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, formatConfigValue(value.Index(i)))
+		}
+		return strings.Join(items, ",")
+	}
+	if !value.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func changedConfigKeys(previous map[string]string, current map[string]string) []string {
+	keys := []string{}
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || old != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseConfigArgs reads --key=value and --key arguments, it stops at --
+func parseConfigArgs(args []string) map[string]string {
+	flags := map[string]string{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !ok {
+			value = "true"
+		}
+		flags[strings.ToLower(key)] = value
+	}
+	return flags
+}
+
+func parseJSONConfig(content []byte, values map[string]string) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	flattenJSONConfig("", document, values)
+	return nil
+}
+
+func flattenJSONConfig(key string, value interface{}, values map[string]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, child := range value {
+			name = strings.ToLower(name)
+			if key != "" {
+				name = key + "." + name
+			}
+			flattenJSONConfig(name, child, values)
+		}
+	case []interface{}:
+		items := []string{}
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[key] = strings.Join(items, ",")
+	case nil:
+		values[key] = ""
+	default:
+		values[key] = fmt.Sprint(value)
+	}
+}
+
+// parseTOMLConfig reads a subset of TOML : [tables], key = value pairs with
+// strings, numbers, booleans and arrays of them, and # comments
+func parseTOMLConfig(content string, values map[string]string) error {
+	table := ""
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(stripConfigComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return fmt.Errorf("line %d : invalid table %s", n+1, line)
+			}
+			table = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d : expected key = value", n+1)
+		}
+		key = strings.ToLower(strings.Trim(strings.TrimSpace(key), `"'`))
+		if table != "" {
+			key = table + "." + key
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("line %d : %w", n+1, err)
+		}
+		values[key] = value
+	}
+	return nil
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("invalid array %s", raw)
+		}
+		items := []string{}
+		for _, item := range splitConfigList(raw[1 : len(raw)-1]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "":
+		return "", errors.New("missing value")
+	}
+	return raw, nil
+}
+
+// parseEnvConfig reads NAME=value lines, values can be quoted and lines can start with export
+func parseEnvConfig(content string, values map[string]string) error {
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, raw, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return fmt.Errorf("line %d : expected NAME=value", n+1)
+		}
+		raw = strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(raw, `"`):
+			value, err := strconv.Unquote(strings.TrimSpace(stripConfigComment(raw)))
+			if err != nil {
+				return fmt.Errorf("line %d : %w", n+1, err)
+			}
+			raw = value
+		case strings.HasPrefix(raw, "'") && len(raw) > 1:
+			raw = strings.TrimSpace(stripConfigComment(raw))
+			raw = strings.TrimSuffix(raw[1:], "'")
+		default:
+			raw = strings.TrimSpace(stripConfigComment(raw))
+		}
+		values[strings.TrimSpace(name)] = raw
+	}
+	return nil
+}
+
+// stripConfigComment removes a # comment outside of quotes
+func stripConfigComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote && (i == 0 || line[i-1] != '\\' || quote == '\''):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitConfigList splits a comma separated list outside of quotes
+func splitConfigList(list string) []string {
+	items := []string{}
+	var quote rune
+	start := 0
+	for i, r := range list {
+		switch {
+		case quote != 0 && r == quote && (i == 0 || list[i-1] != '\\' || quote == '\''):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, list[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, list[start:])
+}
@@ -0,0 +1,187 @@
+package micro
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+/**********************************/
+/*              CSRF              */
+/**********************************/
+
+// CSRFTokenVar is the key of the CSRF token in Context.Vars
+const CSRFTokenVar = "micro.csrf_token"
+
+// CSRFFieldVar is the key of the form field holding the CSRF token in Context.Vars
+const CSRFFieldVar = "micro.csrf_field"
+
+// CSRFExempt is the route attribute that disables CSRF validation when set to true
+const CSRFExempt = "csrf.exempt"
+
+// CSRFMode is the way CSRF tokens are checked
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit stores the token secret in a cookie
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer stores the token secret in the *Session registered by a SessionManager
+	CSRFSynchronizer
+)
+
+const csrfSecretLength = 32
+
+// CSRFProtection is a middleware that protects unsafe requests against cross site request forgery.
+// Requests with a method other than GET, HEAD, OPTIONS and TRACE must send the token
+// returned by Context.CSRFToken in a header or a form field, unless their endpoint
+// has the CSRFExempt attribute. Failures set the 403 status handled by Micro.Error .
+// The form field of multipart bodies is read by Context.Files, so uploads are still
+// streamed to disk within the UploadOptions limits of the endpoint.
+//
+// Example:
+//
+//    app.Use("/", micro.NewCSRFProtection().Handler)
+//    app.Post("/webhook", handler).SetAttribute(micro.CSRFExempt, true)
+type CSRFProtection struct {
+	Mode CSRFMode
+	// Cookie is the template of the cookie holding the secret in CSRFDoubleSubmit mode
+	Cookie http.Cookie
+	// Header is the request header holding the token
+	Header string
+	// Field is the form field holding the token
+	Field string
+}
+
+// NewCSRFProtection returns a new CSRFProtection using double submit cookies
+func NewCSRFProtection() *CSRFProtection {
+	return &CSRFProtection{
+		Mode: CSRFDoubleSubmit,
+		Cookie: http.Cookie{
+			Name:     "csrf_token",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		Header: "X-CSRF-Token",
+		Field:  "csrf_token",
+	}
+}
+
+// Handler makes the token available then validates unsafe requests
+//
+// Can Panic! in CSRFSynchronizer mode if no *Session is registered.
+func (protection *CSRFProtection) Handler(ctx *Context, rw http.ResponseWriter, injector *Injector, next Next) {
+	secret := protection.secret(ctx, rw, injector)
+	ctx.Vars[CSRFTokenVar] = maskCSRFToken(secret)
+	ctx.Vars[CSRFFieldVar] = protection.Field
+	switch ctx.Request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+	default:
+		if endpoint := ctx.Endpoint(); endpoint == nil || endpoint.Attribute(CSRFExempt) != true {
+			token := ctx.Request.Header.Get(protection.Header)
+			if token == "" {
+				var err error
+				if token, err = protection.formToken(ctx); err != nil {
+					// the status of upload limits is kept, other errors fail the validation
+					if code, ok := rw.(*ResponseWriterWithCode); !ok || code.Code() < 400 {
+						rw.WriteHeader(http.StatusForbidden)
+					}
+					next()
+					return
+				}
+			}
+			if !validCSRFToken(token, secret) {
+				rw.WriteHeader(http.StatusForbidden)
+			}
+		}
+	}
+	next()
+}
+
+// formToken returns the token of the form field. Multipart bodies are parsed
+// as Context.Files does, Request.PostFormValue would read them in memory and
+// Request.MultipartReader could not be called afterwards.
+func (protection *CSRFProtection) formToken(ctx *Context) (string, error) {
+	if mediaType, _, _ := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if err := ctx.parseUploads(); err != nil {
+			return "", err
+		}
+		return ctx.Request.PostForm.Get(protection.Field), nil
+	}
+	return ctx.Request.PostFormValue(protection.Field), nil
+}
+
+// secret returns the secret of the client, a new secret is created if needed
+func (protection *CSRFProtection) secret(ctx *Context, rw http.ResponseWriter, injector *Injector) []byte {
+	if protection.Mode == CSRFSynchronizer {
+		session := MustWithResult(injector.Resolve(reflect.TypeOf((*Session)(nil)))).(*Session)
+		if encoded, ok := session.Get(CSRFTokenVar).(string); ok {
+			if secret, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && len(secret) == csrfSecretLength {
+				return secret
+			}
+		}
+		secret := randomBytes(csrfSecretLength)
+		session.Set(CSRFTokenVar, base64.RawURLEncoding.EncodeToString(secret))
+		return secret
+	}
+	if cookie, err := ctx.Request.Cookie(protection.Cookie.Name); err == nil {
+		if secret, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && len(secret) == csrfSecretLength {
+			return secret
+		}
+	}
+	secret := randomBytes(csrfSecretLength)
+	cookie := protection.Cookie
+	cookie.Value = base64.RawURLEncoding.EncodeToString(secret)
+	http.SetCookie(rw, &cookie)
+	return secret
+}
+
+// CSRFToken returns the CSRF token to send with unsafe requests,
+// or an empty string if there is no CSRFProtection middleware
+func (ctx *Context) CSRFToken() string {
+	token, _ := ctx.Vars[CSRFTokenVar].(string)
+	return token
+}
+
+// CSRFField returns the form field holding the CSRF token, the field of
+// NewCSRFProtection if there is no CSRFProtection middleware
+func (ctx *Context) CSRFField() string {
+	if field, ok := ctx.Vars[CSRFFieldVar].(string); ok && field != "" {
+		return field
+	}
+	return NewCSRFProtection().Field
+}
+
+// maskCSRFToken xors the secret with a random pad so the token changes with
+// every response, which prevents BREACH attacks
+func maskCSRFToken(secret []byte) string {
+	pad := randomBytes(len(secret))
+	masked := make([]byte, len(secret))
+	for i := range secret {
+		masked[i] = pad[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(append(pad, masked...))
+}
+
+func validCSRFToken(token string, secret []byte) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) != 2*len(secret) {
+		return false
+	}
+	pad, masked := decoded[:len(secret)], decoded[len(secret):]
+	unmasked := make([]byte, len(secret))
+	for i := range secret {
+		unmasked[i] = pad[i] ^ masked[i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+func randomBytes(length int) []byte {
+	b := make([]byte, length)
+	rand.Read(b)
+	return b
+}
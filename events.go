@@ -0,0 +1,444 @@
+package micro
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**********************************/
+/*         EVENT EMITTER          */
+/**********************************/
+
+// Listener is an event handler function.
+// A listener returning false stops the propagation of the event.
+type Listener *func(string, ...interface{}) bool
+
+// listener is a registered Listener
+type listener struct {
+	id       uint64
+	pattern  string
+	function Listener
+	priority int
+	once     bool
+	// fired is set when a listener added with Once is called
+	fired atomic.Bool
+}
+
+// EventEmitter listens for and emits events. It is safe for concurrent use.
+//
+// Events are namespaced with dots, such as "request.start".
+// Listeners can listen to a pattern where * matches a segment
+// of the event name, a trailing * matches all remaining segments :
+// "request.*" matches "request.start" and "*" matches every event.
+type EventEmitter struct {
+	mutex sync.RWMutex
+	// handlers are the listeners by pattern
+	handlers map[string][]*listener
+	// wildcards are the segments of the patterns with a *
+	wildcards map[string][]string
+	lastID    uint64
+	workers  int
+	queue    chan func()
+	stop     chan struct{}
+	pending  sync.WaitGroup
+	closed   bool
+}
+
+// NewEventEmitter returns a new event emitter
+func NewEventEmitter() *EventEmitter {
+	return &EventEmitter{
+		handlers:  map[string][]*listener{},
+		wildcards: map[string][]string{},
+		workers:   runtime.NumCPU(),
+	}
+}
+
+// On adds a listener for events matching pattern and
+// returns a function that removes the listener.
+func (em *EventEmitter) On(pattern string, function func(string, ...interface{}) bool) func() {
+	return em.add(pattern, &function, 0, false)
+}
+
+// OnWithPriority adds a listener with a priority, listeners with a higher priority are called first.
+// Listeners with the same priority are called in the order they were added.
+func (em *EventEmitter) OnWithPriority(pattern string, priority int, function func(string, ...interface{}) bool) func() {
+	return em.add(pattern, &function, priority, false)
+}
+
+// Once adds a listener that is removed after it has been called once
+func (em *EventEmitter) Once(pattern string, function func(string, ...interface{}) bool) func() {
+	return em.add(pattern, &function, 0, true)
+}
+
+// Emit emits an event, listeners are called synchronously
+func (em *EventEmitter) Emit(event string, arguments ...interface{}) {
+	em.dispatch(event, em.listeners(event), arguments)
+}
+
+// EmitAsync emits an event, listeners are called by a pool of workers.
+// Listeners of an event are called in order by a single worker, a panic
+// of a listener is logged and stops the propagation of the event.
+// EmitAsync blocks if all workers are busy and the queue is full.
+// Once the emitter is closed, listeners are called synchronously.
+// The returned channel is closed once all listeners have been called.
+func (em *EventEmitter) EmitAsync(event string, arguments ...interface{}) <-chan struct{} {
+	done := make(chan struct{})
+	listeners := em.listeners(event)
+	task := func() {
+		defer close(done)
+		em.dispatch(event, listeners, arguments)
+	}
+	if queue := em.startWorkers(); queue != nil {
+		queue <- task
+	} else {
+		runTask(task)
+	}
+	return done
+}
+
+// Close waits for the workers of EmitAsync to call the listeners of the events
+// already emitted, then stops them. Calling Close more than once does nothing.
+func (em *EventEmitter) Close() {
+	em.mutex.Lock()
+	if em.closed {
+		em.mutex.Unlock()
+		return
+	}
+	em.closed = true
+	em.mutex.Unlock()
+	em.pending.Wait()
+	if em.stop != nil {
+		close(em.stop)
+	}
+}
+
+// SetWorkers sets the number of workers used by EmitAsync, it defaults to the number of CPUs.
+// It has no effect once EmitAsync has been called.
+func (em *EventEmitter) SetWorkers(workers int) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	if workers > 0 {
+		em.workers = workers
+	}
+}
+
+// AddListener adds a new listener function pointer
+func (em *EventEmitter) AddListener(event string, listener Listener) {
+	em.add(event, listener, 0, false)
+}
+
+// RemoveListener removes a listener function pointer
+func (em *EventEmitter) RemoveListener(event string, listener Listener) bool {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	for i, l := range em.handlers[event] {
+		if l.function == listener {
+			em.removeAt(event, i)
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAllListeners remove all listeners given an event and returns the listener slice
+func (em *EventEmitter) RemoveAllListeners(event string) []Listener {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	listeners := []Listener{}
+	for _, l := range em.handlers[event] {
+		listeners = append(listeners, l.function)
+	}
+	delete(em.handlers, event)
+	delete(em.wildcards, event)
+	return listeners
+}
+
+// HasListener returns true if an event has listeners
+func (em *EventEmitter) HasListener(event string) bool {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+	if len(em.handlers[event]) > 0 {
+		return true
+	}
+	eventSegments := strings.Split(event, ".")
+	for _, segments := range em.wildcards {
+		if matchSegments(segments, eventSegments) {
+			return true
+		}
+	}
+	return false
+}
+
+func (em *EventEmitter) add(pattern string, function Listener, priority int, once bool) func() {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.lastID++
+	l := &listener{id: em.lastID, pattern: pattern, function: function, priority: priority, once: once}
+	em.handlers[pattern] = append(em.handlers[pattern], l)
+	if strings.Contains(pattern, "*") {
+		em.wildcards[pattern] = strings.Split(pattern, ".")
+	}
+	return func() { em.remove(l) }
+}
+
+func (em *EventEmitter) remove(l *listener) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	for i, registered := range em.handlers[l.pattern] {
+		if registered == l {
+			em.removeAt(l.pattern, i)
+			return
+		}
+	}
+}
+
+// removeAt removes a listener, the caller must hold the lock
+func (em *EventEmitter) removeAt(pattern string, i int) {
+	listeners := em.handlers[pattern]
+	em.handlers[pattern] = append(listeners[:i:i], listeners[i+1:]...)
+	if len(em.handlers[pattern]) == 0 {
+		delete(em.handlers, pattern)
+		delete(em.wildcards, pattern)
+	}
+}
+
+// listeners returns the listeners of an event sorted by priority
+func (em *EventEmitter) listeners(event string) []*listener {
+	em.mutex.RLock()
+	listeners := append([]*listener{}, em.handlers[event]...)
+	if len(em.wildcards) > 0 {
+		eventSegments := strings.Split(event, ".")
+		for pattern, segments := range em.wildcards {
+			if pattern != event && matchSegments(segments, eventSegments) {
+				listeners = append(listeners, em.handlers[pattern]...)
+			}
+		}
+	}
+	em.mutex.RUnlock()
+	sort.Slice(listeners, func(i, j int) bool {
+		if listeners[i].priority != listeners[j].priority {
+			return listeners[i].priority > listeners[j].priority
+		}
+		return listeners[i].id < listeners[j].id
+	})
+	return listeners
+}
+
+// startWorkers starts the worker pool if needed and returns its queue,
+// or nil if the emitter is closed. The caller must send a task to the queue.
+func (em *EventEmitter) startWorkers() chan<- func() {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	if em.closed {
+		return nil
+	}
+	if em.queue == nil {
+		em.queue, em.stop = make(chan func(), em.workers), make(chan struct{})
+		for i := 0; i < em.workers; i++ {
+			go func(queue <-chan func(), stop <-chan struct{}) {
+				for {
+					select {
+					case task := <-queue:
+						runTask(task)
+						em.pending.Done()
+					case <-stop:
+						return
+					}
+				}
+			}(em.queue, em.stop)
+		}
+	}
+	em.pending.Add(1)
+	return em.queue
+}
+
+// runTask calls task and logs its panic so that a listener cannot crash the process
+func runTask(task func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("event listener panic : %v\n%s", err, debug.Stack())
+		}
+	}()
+	task()
+}
+
+// dispatch calls listeners until one of them returns false,
+// a listener added with Once is removed the first time it is called.
+func (em *EventEmitter) dispatch(event string, listeners []*listener, arguments []interface{}) {
+	for _, l := range listeners {
+		if l.once {
+			if !l.fired.CompareAndSwap(false, true) {
+				continue
+			}
+			em.remove(l)
+		}
+		if !(*l.function)(event, arguments...) {
+			break
+		}
+	}
+}
+
+// matchSegments returns true if the segments of an event name match the segments of a listener pattern
+func matchSegments(patternSegments, eventSegments []string) bool {
+	for i, segment := range patternSegments {
+		if i >= len(eventSegments) {
+			return false
+		}
+		if segment == "*" && i == len(patternSegments)-1 {
+			return true
+		}
+		if segment != "*" && segment != eventSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(eventSegments)
+}
+
+/**********************************/
+/*          TYPED EVENTS          */
+/**********************************/
+
+// Subscribe adds a listener called with the payload of every event
+// whose first argument is a T, whether it was emitted by Publish or Emit.
+// Each lifecycle event has its own payload type, such as RouteMatchedEvent.
+// It returns a function that removes the listener.
+//
+// Example:
+//
+//    micro.Subscribe(app.EventEmitter, func(end micro.RequestEndEvent) error {
+//        log.Println(end.Request.URL, end.Status)
+//        return nil
+//    })
+func Subscribe[T any](emitter *EventEmitter, listener func(T) error) func() {
+	return emitter.On("*", func(event string, arguments ...interface{}) bool {
+		if len(arguments) == 0 {
+			return true
+		}
+		payload, ok := arguments[0].(T)
+		if !ok {
+			return true
+		}
+		err := listener(payload)
+		if len(arguments) > 1 {
+			if p, ok := arguments[1].(*publication); ok {
+				p.errors = append(p.errors, err)
+				return true
+			}
+		}
+		// events emitted with Emit cannot report errors
+		if err != nil {
+			log.Println(err)
+		}
+		return true
+	})
+}
+
+// Publish emits payload to the subscribers of T and returns the errors they returned joined.
+// The event name is EventName[T]() so untyped listeners can also listen to it.
+func Publish[T any](emitter *EventEmitter, payload T) error {
+	p := &publication{}
+	emitter.Emit(EventName[T](), payload, p)
+	return errors.Join(p.errors...)
+}
+
+// EventName returns the name of the event emitted by Publish for a T,
+// the package qualified name of the type, such as micro.RequestEndEvent
+func EventName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// publication collects the errors returned by subscribers
+type publication struct {
+	errors []error
+}
+
+/**********************************/
+/*        LIFECYCLE EVENTS        */
+/**********************************/
+
+// Events emitted by Micro during a request, the payload of each event
+// is the first argument given to listeners.
+//
+// Example:
+//
+//    app.On(micro.EventRequestEnd, func(event string, arguments ...interface{}) bool {
+//        end := arguments[0].(micro.RequestEndEvent)
+//        log.Println(end.Request.URL, end.Status, end.Duration)
+//        return true
+//    })
+const (
+	// EventRequestStart is emitted when a request is received, with a RequestStartEvent
+	EventRequestStart = "request.start"
+	// EventRouteMatched is emitted when a route is about to handle the request, with a RouteMatchedEvent
+	EventRouteMatched = "route.matched"
+	// EventHandlerBefore is emitted before a route handler is called, with a HandlerBeforeEvent
+	EventHandlerBefore = "handler.before"
+	// EventHandlerAfter is emitted after a route handler returned, with a HandlerAfterEvent.
+	// A handler calling next returns after the handlers of the next routes.
+	EventHandlerAfter = "handler.after"
+	// EventStatusError is emitted when a handler set a status greater than 399, with a StatusErrorEvent
+	EventStatusError = "response.status_error"
+	// EventPanic is emitted when a handler panics, with a PanicEvent
+	EventPanic = "panic"
+	// EventRequestEnd is emitted once the request has been handled, with a RequestEndEvent
+	EventRequestEnd = "request.end"
+)
+
+// RequestStartEvent is the payload of EventRequestStart
+type RequestStartEvent struct {
+	Request *http.Request
+	Context *Context
+}
+
+// RouteEvent holds the request and the route of the route events,
+// each event has its own payload type so that Subscribe can tell them apart
+type RouteEvent struct {
+	Request *http.Request
+	Route   *Route
+}
+
+// RouteMatchedEvent is the payload of EventRouteMatched
+type RouteMatchedEvent RouteEvent
+
+// HandlerBeforeEvent is the payload of EventHandlerBefore
+type HandlerBeforeEvent RouteEvent
+
+// HandlerAfterEvent is the payload of EventHandlerAfter
+type HandlerAfterEvent RouteEvent
+
+// StatusErrorEvent is the payload of EventStatusError
+type StatusErrorEvent struct {
+	Request *http.Request
+	Status  int
+}
+
+// PanicEvent is the payload of EventPanic
+type PanicEvent struct {
+	Request *http.Request
+	// Route is the route whose handler panicked, it can be nil
+	Route *Route
+	Error interface{}
+	Stack []byte
+}
+
+// RequestEndEvent is the payload of EventRequestEnd
+type RequestEndEvent struct {
+	Request *http.Request
+	// Route is the last route that handled the request, it is nil if no route matched
+	Route *Route
+	// Endpoint is the first matched route that is not a passthrough route,
+	// it is nil if only passthrough routes matched, see Context.Endpoint
+	Endpoint *Route
+	Status   int
+	Length   int
+	Duration time.Duration
+}
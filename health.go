@@ -0,0 +1,265 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**********************************/
+/*             HEALTH             */
+/**********************************/
+
+// HealthCheck checks a dependency of the application, such as a database,
+// it returns an error if the dependency is unhealthy. ctx is done once the
+// timeout of the check expired.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckKind tells which endpoints run a check
+type HealthCheckKind int
+
+const (
+	// Liveness checks fail when the application must be restarted
+	Liveness HealthCheckKind = 1 << iota
+	// Readiness checks fail when the application cannot handle requests for now
+	Readiness
+	// AllChecks is the kind of both liveness and readiness checks
+	AllChecks = Liveness | Readiness
+)
+
+const (
+	// HealthPass is the status of passing checks and reports
+	HealthPass = "pass"
+	// HealthFail is the status of failing checks and reports
+	HealthFail = "fail"
+)
+
+// HealthReport is the JSON report of health endpoints
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// HealthCheckResult is the result of a check in a HealthReport
+type HealthCheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// healthCheck is a registered HealthCheck
+type healthCheck struct {
+	name    string
+	kind    HealthCheckKind
+	timeout time.Duration
+	check   HealthCheck
+}
+
+// HealthRegistry holds the named checks of health endpoints. It is safe for concurrent use.
+type HealthRegistry struct {
+	// Timeout is the timeout of checks registered without a timeout, 5 seconds by default
+	Timeout  time.Duration
+	mutex    sync.RWMutex
+	checks   []*healthCheck
+	draining atomic.Bool
+}
+
+// NewHealthRegistry returns a new HealthRegistry
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{Timeout: 5 * time.Second}
+}
+
+// Register adds a check run by the endpoints of kind, a check registered
+// with the name of another check replaces it. A timeout of 0 means the Timeout of the registry.
+//
+// Example:
+//
+//    app.Health("/healthz").Register("database", micro.Readiness, 2*time.Second, func(ctx context.Context) error {
+//        return db.PingContext(ctx)
+//    })
+func (registry *HealthRegistry) Register(name string, kind HealthCheckKind, timeout time.Duration, check HealthCheck) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for i, registered := range registry.checks {
+		if registered.name == name {
+			registry.checks = append(registry.checks[:i], registry.checks[i+1:]...)
+			break
+		}
+	}
+	registry.checks = append(registry.checks, &healthCheck{name: name, kind: kind, timeout: timeout, check: check})
+}
+
+// Drain makes readiness reports fail from then on, Micro.Shutdown calls it
+func (registry *HealthRegistry) Drain() {
+	registry.draining.Store(true)
+}
+
+// Draining returns true once Drain has been called
+func (registry *HealthRegistry) Draining() bool {
+	return registry.draining.Load()
+}
+
+// Check runs the checks of kind concurrently and returns their report.
+// Reports including readiness checks have a "draining" check failing once Drain has been called.
+func (registry *HealthRegistry) Check(ctx context.Context, kind HealthCheckKind) HealthReport {
+	registry.mutex.RLock()
+	checks := []*healthCheck{}
+	for _, check := range registry.checks {
+		if check.kind&kind != 0 {
+			checks = append(checks, check)
+		}
+	}
+	timeout := registry.Timeout
+	registry.mutex.RUnlock()
+	report := HealthReport{Status: HealthPass, Checks: map[string]HealthCheckResult{}}
+	results := make([]HealthCheckResult, len(checks))
+	wg := sync.WaitGroup{}
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *healthCheck) {
+			defer wg.Done()
+			if check.timeout > 0 {
+				results[i] = check.run(ctx, check.timeout)
+			} else {
+				results[i] = check.run(ctx, timeout)
+			}
+		}(i, check)
+	}
+	wg.Wait()
+	for i, check := range checks {
+		report.Checks[check.name] = results[i]
+	}
+	if kind&Readiness != 0 && registry.Draining() {
+		report.Checks["draining"] = HealthCheckResult{Status: HealthFail, Error: "the application is shutting down", Duration: "0s"}
+	}
+	for _, result := range report.Checks {
+		if result.Status == HealthFail {
+			report.Status = HealthFail
+		}
+	}
+	return report
+}
+
+// run runs the check, a check ignoring the context does not delay the report past the timeout
+func (check *healthCheck) run(ctx context.Context, timeout time.Duration) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- fmt.Errorf("panic : %v", err)
+			}
+		}()
+		done <- check.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	result := HealthCheckResult{Status: HealthPass, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		result.Status, result.Error = HealthFail, err.Error()
+	}
+	return result
+}
+
+// Handler returns a handler writing the report of the checks of kind,
+// with the 200 status if it passes or the 503 status if it fails
+func (registry *HealthRegistry) Handler(kind HealthCheckKind) func(ctx *Context) {
+	return func(ctx *Context) {
+		report := registry.Check(ctx.Request.Context(), kind)
+		ctx.Response.Header().Set("Content-Type", "application/json")
+		ctx.Response.Header().Set("Cache-Control", "no-store")
+		if report.Status == HealthPass {
+			ctx.Response.WriteHeader(http.StatusOK)
+		} else {
+			ctx.Response.WriteHeader(http.StatusServiceUnavailable)
+		}
+		Must(ctx.WriteJSON(report))
+	}
+}
+
+// Health serves the report of all checks at path and returns the health registry of the application
+func (e *Micro) Health(path string) *HealthRegistry {
+	return e.healthEndpoint(path, AllChecks)
+}
+
+// Readiness serves the report of readiness checks at path and returns the health registry of the application.
+// Orchestrators stop sending requests to the application while it fails.
+func (e *Micro) Readiness(path string) *HealthRegistry {
+	return e.healthEndpoint(path, Readiness)
+}
+
+// Liveness serves the report of liveness checks at path and returns the health registry of the application.
+// Orchestrators restart the application when it fails.
+func (e *Micro) Liveness(path string) *HealthRegistry {
+	return e.healthEndpoint(path, Liveness)
+}
+
+// HealthChecks returns the health registry of the application
+func (e *Micro) HealthChecks() *HealthRegistry {
+	return e.health
+}
+
+func (e *Micro) healthEndpoint(path string, kind HealthCheckKind) *HealthRegistry {
+	e.Get(path, e.health.Handler(kind)).SetAttribute(OpenAPIIgnore, true)
+	return e.health
+}
+
+// Shutdown gracefully stops the application : readiness reports fail from then on so that
+// load balancers stop sending requests, then after ShutdownDelay the servers stop accepting
+// connections and Shutdown waits for their active requests to complete or for ctx to be done.
+// Finally the event emitter of the application is closed once the listeners of the events
+// emitted with EmitAsync have been called, or when ctx is done.
+//
+// Example:
+//
+//    server := &http.Server{Addr: ":8080", Handler: app}
+//    go server.ListenAndServe()
+//    <-signals
+//    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//    defer cancel()
+//    app.Shutdown(ctx, server)
+func (e *Micro) Shutdown(ctx context.Context, servers ...*http.Server) error {
+	e.health.Drain()
+	if e.ShutdownDelay > 0 {
+		timer := time.NewTimer(e.ShutdownDelay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	errs := make([]error, len(servers))
+	wg := sync.WaitGroup{}
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *http.Server) {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}(i, server)
+	}
+	wg.Wait()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		e.EventEmitter.Close()
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+package micro
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+/**********************************/
+/*           HTTP CLIENT          */
+/**********************************/
+
+// NewHTTPClient returns an *http.Client with default timeouts whose requests carry
+// the request ID and the trace context headers attached to their context.Context
+// by RequestIDMiddleware. base is the transport sending requests, the default one if nil.
+//
+// Example:
+//
+//    app.Get("/movies", func(ctx *micro.Context, client *http.Client) {
+//        request, _ := http.NewRequestWithContext(ctx.Request.Context(), "GET", "http://catalog/movies", nil)
+//        response, err := client.Do(request)
+//    })
+func NewHTTPClient(base http.RoundTripper) *http.Client {
+	if base == nil {
+		base = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		}
+	}
+	return &http.Client{
+		Transport: NewPropagatingTransport(base),
+		Timeout:   30 * time.Second,
+	}
+}
+
+// HTTPClient returns the *http.Client registered in the injector of the application.
+// New registers a client returned by NewHTTPClient, register another *http.Client
+// to configure it.
+func (e *Micro) HTTPClient() *http.Client {
+	client, _ := e.injector.Resolve(reflect.TypeOf((*http.Client)(nil)))
+	httpClient, _ := client.(*http.Client)
+	return httpClient
+}
+
+// PropagatingTransport is an http.RoundTripper forwarding the request ID and the
+// trace context headers attached to the context.Context of requests
+type PropagatingTransport struct {
+	// Base sends the requests
+	Base http.RoundTripper
+	// RequestIDHeader is the header holding the request ID
+	RequestIDHeader string
+}
+
+// NewPropagatingTransport returns a PropagatingTransport using the X-Request-ID header
+func NewPropagatingTransport(base http.RoundTripper) *PropagatingTransport {
+	return &PropagatingTransport{Base: base, RequestIDHeader: "X-Request-ID"}
+}
+
+// RoundTrip adds the headers to a copy of the request then sends it
+func (transport *PropagatingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	id, trace := RequestIDFromContext(request.Context()), TraceHeadersFromContext(request.Context())
+	if id != "" || trace != nil {
+		// a RoundTripper must not modify the request
+		request = request.Clone(request.Context())
+		if id != "" && request.Header.Get(transport.RequestIDHeader) == "" {
+			request.Header.Set(transport.RequestIDHeader, id)
+		}
+		for name, values := range trace {
+			if request.Header.Get(name) == "" {
+				request.Header[name] = values
+			}
+		}
+	}
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(request)
+}
@@ -4,6 +4,7 @@ import	(
 	"reflect" 
 	"fmt"
 	"runtime/debug"
+	"sync"
 )
 
 /**********************************/
@@ -11,8 +12,9 @@ import	(
 /**********************************/
 
 // Injector is a dependency injection container
-// Based on types.
+// Based on types. It is safe for concurrent use.
 type Injector struct {
+	mutex    sync.RWMutex
 	services map[reflect.Type]interface{}
 	parent   *Injector
 }
@@ -28,6 +30,8 @@ func NewInjector(services ...interface{}) *Injector {
 
 // Register registers a new service to the injector
 func (i *Injector) Register(service interface{}) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
 	i.services[reflect.ValueOf(service).Type()] = service
 }
 
@@ -36,26 +40,68 @@ func (i *Injector) RegisterWithType(service interface{}, Type interface{}) {
 	if !reflect.TypeOf(service).ConvertibleTo(reflect.TypeOf(Type)) {
 		panic(fmt.Sprint(service, " is not convertible to ", Type))
 	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
 	i.services[reflect.TypeOf(Type)] = service
 }
 
+// Replace registers service in place of the services of its type,
+// or of the services implementing Type if Type is a pointer to an interface.
+// It returns a function that restores the replaced services.
+//
+// Example:
+//
+//    restore := injector.Replace(&FakeMailer{}, (*Mailer)(nil))
+//    defer restore()
+func (i *Injector) Replace(service interface{}, Type interface{}) (restore func()) {
+	serviceType := reflect.TypeOf(service)
+	var iface reflect.Type
+	if t := reflect.TypeOf(Type); t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		iface = t.Elem()
+	}
+	replaced := map[reflect.Type]interface{}{}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for typeService, registered := range i.services {
+		if typeService == serviceType || (iface != nil && typeService.Implements(iface)) {
+			replaced[typeService] = registered
+			delete(i.services, typeService)
+		}
+	}
+	i.services[serviceType] = service
+	return func() {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		delete(i.services, serviceType)
+		for typeService, registered := range replaced {
+			i.services[typeService] = registered
+		}
+	}
+}
+
 // Resolve fetch the value according to a registered type
 func (i *Injector) Resolve(someType reflect.Type) (interface{}, error) {
 	var (
 		err     error
 		service interface{}
 	)
+	i.mutex.RLock()
 	for typeService, service := range i.services {
 		if typeService == someType {
+			i.mutex.RUnlock()
 			return service, nil
 		} else if someType.Kind() == reflect.Interface && typeService.Implements(someType) {
+			i.mutex.RUnlock()
 			return service, nil
 		} else if someType.Kind() == reflect.Ptr && someType.Elem().Kind() == reflect.Interface && typeService.Implements(someType.Elem()) {
+			i.mutex.RUnlock()
 			return service, nil
 		}
 	}
-	if service == nil && i.parent != nil && i.parent != i {
-		service, err = i.parent.Resolve(someType)
+	parent := i.parent
+	i.mutex.RUnlock()
+	if service == nil && parent != nil && parent != i {
+		service, err = parent.Resolve(someType)
 	}
 	if service == nil {
 		err = fmt.Errorf("service with type %v cannot be injected : not found", someType)
@@ -98,10 +144,14 @@ func (i *Injector) MustApply(function interface{}) (results []interface{}) {
 
 // SetParent sets the injector's parent
 func (i *Injector) SetParent(parent *Injector) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
 	i.parent = parent
 }
 
 // Parent gets the injector's parent
-func (i Injector) Parent() *Injector {
+func (i *Injector) Parent() *Injector {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
 	return i.parent
 }
\ No newline at end of file
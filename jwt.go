@@ -0,0 +1,229 @@
+package micro
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/**********************************/
+/*               JWT              */
+/**********************************/
+
+// JWTKeySet maps key IDs to verification keys : []byte secrets for HS256,
+// *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+// The key with an empty ID is used for tokens without kid header.
+type JWTKeySet map[string]interface{}
+
+// JWTAuth is a middleware authenticating requests with a JSON Web Token
+// in the Authorization header. HS256, RS256 and ES256 signatures are supported.
+//
+// The principal subject is the sub claim, its roles the roles claim and
+// its scopes the space separated scope claim.
+//
+// Example:
+//
+//    keys := micro.MustWithResult(micro.LoadJWKS("jwks.json")).(micro.JWTKeySet)
+//    app.Use("/api", micro.NewJWTAuth("api", keys).Handler)
+type JWTAuth struct {
+	Realm string
+	Keys  JWTKeySet
+	// Issuer, if not empty, must be the iss claim
+	Issuer string
+	// Audience, if not empty, must be in the aud claim
+	Audience string
+	// Leeway is the clock skew allowed when checking exp and nbf claims
+	Leeway time.Duration
+}
+
+// NewJWTAuth returns a new JWTAuth
+func NewJWTAuth(realm string, keys JWTKeySet) *JWTAuth {
+	return &JWTAuth{Realm: realm, Keys: keys, Leeway: time.Minute}
+}
+
+// Handler authenticates the request or sets the 401 status
+func (auth *JWTAuth) Handler(r *http.Request, rw http.ResponseWriter, injector *Injector, next Next) {
+	authenticate(auth.Realm, auth.Verify, r, rw, injector, next)
+}
+
+// Verify checks the signature and the claims of a token and returns its principal
+func (auth *JWTAuth) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	key, ok := auth.Keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.Kid)
+	}
+	if err = verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = auth.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	principal := &Principal{Claims: claims}
+	principal.Subject, _ = claims["sub"].(string)
+	principal.Roles = stringsClaim(claims["roles"])
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	}
+	return principal, nil
+}
+
+func (auth *JWTAuth) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(auth.Leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-auth.Leeway)) {
+		return errors.New("token is not valid yet")
+	}
+	if auth.Issuer != "" && claims["iss"] != auth.Issuer {
+		return errors.New("invalid issuer")
+	}
+	if auth.Audience != "" && !containsString(stringsClaim(claims["aud"]), auth.Audience) {
+		return errors.New("invalid audience")
+	}
+	return nil
+}
+
+// verifyJWTSignature checks the signature with a key whose type must match the algorithm,
+// so that a public key cannot be used as a HMAC secret.
+func verifyJWTSignature(alg string, key interface{}, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	invalid := errors.New("invalid signature")
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return invalid
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) != nil {
+			return invalid
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || publicKey.Curve != elliptic.P256() || len(signature) != 64 {
+			return invalid
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, hash[:], r, s) {
+			return invalid
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// stringsClaim converts a string or an array claim to a slice
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// LoadJWKS reads a JSON Web Key Set file. RSA, P-256 EC and oct keys are loaded,
+// other keys are ignored.
+func LoadJWKS(path string) (JWTKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := JWTKeySet{}
+	for _, jwk := range jwks.Keys {
+		values := map[string][]byte{}
+		for name, value := range map[string]string{"n": jwk.N, "e": jwk.E, "x": jwk.X, "y": jwk.Y, "k": jwk.K} {
+			if values[name], err = base64.RawURLEncoding.DecodeString(value); err != nil {
+				return nil, fmt.Errorf("key %q : invalid %s", jwk.Kid, name)
+			}
+		}
+		switch {
+		case jwk.Kty == "RSA":
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(values["n"]),
+				E: int(new(big.Int).SetBytes(values["e"]).Int64()),
+			}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			keys[jwk.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(values["x"]),
+				Y:     new(big.Int).SetBytes(values["y"]),
+			}
+		case jwk.Kty == "oct":
+			keys[jwk.Kid] = values["k"]
+		}
+	}
+	return keys, nil
+}
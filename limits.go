@@ -0,0 +1,222 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*             LIMITS             */
+/**********************************/
+
+const (
+	// MaxBodyBytesAttribute is the route attribute holding the maximum size in bytes
+	// of request bodies as an int64, it replaces Micro.MaxBodyBytes
+	MaxBodyBytesAttribute = "limits.maxbodybytes"
+	// TimeoutAttribute is the route attribute holding the time.Duration after which
+	// the request context is canceled and the Micro.TimeoutStatus error response is written,
+	// like http.TimeoutHandler does. Responses are buffered until the handlers return,
+	// a handler flushing its response streams it and completes it despite the timeout.
+	// Handlers should stop once the request context is done, their writes fail after the timeout.
+	TimeoutAttribute = "limits.timeout"
+	// ReadTimeoutAttribute is the route attribute holding the time.Duration
+	// allowed to read the request body
+	ReadTimeoutAttribute = "limits.readtimeout"
+)
+
+// limit applies the body size limit and the read timeout of the endpoint of the request
+func (e *Micro) limit(rw *ResponseWriterWithCode, ctx *Context) {
+	maxBodyBytes, endpoint := e.MaxBodyBytes, ctx.Endpoint()
+	var readTimeout time.Duration
+	if endpoint != nil {
+		if value, ok := endpoint.Attribute(MaxBodyBytesAttribute).(int64); ok {
+			maxBodyBytes = value
+		}
+		readTimeout, _ = endpoint.Attribute(ReadTimeoutAttribute).(time.Duration)
+	}
+	request := ctx.Request
+	if maxBodyBytes > 0 && request.Body != nil {
+		if request.ContentLength > maxBodyBytes {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+		request.Body = http.MaxBytesReader(rw.ResponseWriter, request.Body, maxBodyBytes)
+	}
+	if readTimeout > 0 {
+		// not every ResponseWriter supports deadlines, the limit is best effort
+		http.NewResponseController(rw).SetReadDeadline(time.Now().Add(readTimeout))
+	}
+}
+
+// serveWithTimeout handles the request in another goroutine with a response buffered
+// by a timeoutWriter. If the handlers have not returned after timeout, the
+// Micro.TimeoutStatus error response is written and serveWithTimeout returns.
+// Like http.TimeoutHandler, the handlers keep running until they return.
+func (e *Micro) serveWithTimeout(responseWriter http.ResponseWriter, request *http.Request, matches []*Route, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	defer cancel()
+	writer := newTimeoutWriter(responseWriter)
+	done, panicked := make(chan struct{}), make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				panicked <- err
+			}
+		}()
+		e.serve(writer, request.WithContext(ctx), matches)
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		writer.commit()
+		return
+	case err := <-panicked:
+		panic(err)
+	case <-timer.C:
+	}
+	if !writer.expire(func() (int, int) { return e.writeTimeout(responseWriter, request, matches) }) {
+		// the handlers already streamed a part of the response, they complete it
+		select {
+		case <-done:
+			writer.commit()
+		case err := <-panicked:
+			panic(err)
+		}
+	}
+}
+
+// writeTimeout writes the Micro.TimeoutStatus error response with a new request context,
+// it returns the status and the length of the response
+func (e *Micro) writeTimeout(responseWriter http.ResponseWriter, request *http.Request, matches []*Route) (int, int) {
+	rw := &ResponseWriterWithCode{ResponseWriter: responseWriter}
+	context := NewContext(rw, request)
+	context.app, context.matches = e, matches
+	injector := e.newRequestInjector(rw, request, context)
+	rw.WriteHeader(e.TimeoutStatus)
+	e.hasErrorCode(rw, request, injector)
+	return rw.Code(), rw.Length()
+}
+
+// timedOut sets the Micro.TimeoutStatus status and returns true if the endpoint timeout
+// expired before the response header was written, so that handlers returning once
+// the request context is done get the timeout response
+func (e *Micro) timedOut(rw *ResponseWriterWithCode, ctx *Context) bool {
+	if rw.HeaderWritten() {
+		return false
+	}
+	if endpoint := ctx.Endpoint(); endpoint == nil || endpoint.Attribute(TimeoutAttribute) == nil {
+		return false
+	}
+	if !errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+	rw.WriteHeader(e.TimeoutStatus)
+	return true
+}
+
+// timeoutWriter buffers the response of handlers running with a timeout,
+// its header is not the header of the target so that the timeout response can be written
+// while handlers are running. Writes fail with http.ErrHandlerTimeout once the timeout expired.
+// Flushing commits the response, the timeout response can no longer be written then.
+type timeoutWriter struct {
+	mutex   sync.Mutex
+	buffer  *responseBuffer
+	expired bool
+	code    int
+	length  int
+}
+
+func newTimeoutWriter(target http.ResponseWriter) *timeoutWriter {
+	buffer := newResponseBuffer(target, 0)
+	buffer.header = http.Header{}
+	return &timeoutWriter{buffer: buffer}
+}
+
+// Header returns the header of the buffered response
+func (writer *timeoutWriter) Header() http.Header {
+	return writer.buffer.Header()
+}
+
+// WriteHeader records the status code
+func (writer *timeoutWriter) WriteHeader(code int) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if !writer.expired {
+		writer.buffer.WriteHeader(code)
+	}
+}
+
+// Write buffers the body, or fails with http.ErrHandlerTimeout once the timeout expired
+func (writer *timeoutWriter) Write(b []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.expired {
+		return 0, http.ErrHandlerTimeout
+	}
+	return writer.buffer.Write(b)
+}
+
+// Flush commits the response and flushes the target unless the timeout expired
+func (writer *timeoutWriter) Flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if !writer.expired {
+		writer.buffer.Flush()
+	}
+}
+
+// Unwrap returns the target, so http.ResponseController can reach it
+func (writer *timeoutWriter) Unwrap() http.ResponseWriter {
+	return writer.buffer.target
+}
+
+// commit writes the buffered response to the target
+func (writer *timeoutWriter) commit() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.buffer.commit()
+}
+
+// discard removes the buffered status and body if the response has not been committed
+// and the timeout has not expired
+func (writer *timeoutWriter) discard() bool {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return !writer.expired && writer.buffer.discard()
+}
+
+// expire writes the timeout response with write and returns true if the response
+// has not been committed, later writes of the handlers fail
+func (writer *timeoutWriter) expire(write func() (code int, length int)) bool {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.buffer.committed {
+		return false
+	}
+	writer.expired = true
+	writer.code, writer.length = write()
+	return true
+}
+
+// result returns the status and the length of the response actually written
+func (writer *timeoutWriter) result(code int, length int) (int, int) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.expired {
+		return writer.code, writer.length
+	}
+	return code, length
+}
+
+// bodyError sets the 413 status if err is due to a body too large
+func (ctx *Context) bodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		ctx.Response.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	return err
+}
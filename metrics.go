@@ -0,0 +1,233 @@
+package micro
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/**********************************/
+/*             METRICS            */
+/**********************************/
+
+// DefaultBuckets are the upper bounds in seconds of the request duration histogram buckets
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector writes metrics in the Prometheus text exposition format
+type Collector interface {
+	Collect(w io.Writer) error
+}
+
+// CollectorFunc is a function used as a Collector
+type CollectorFunc func(w io.Writer) error
+
+// Collect calls the function
+func (f CollectorFunc) Collect(w io.Writer) error {
+	return f(w)
+}
+
+// Metrics collects request metrics from the lifecycle events of an application.
+// Requests are labeled by the name of their endpoint, see Context.Endpoint, so the number
+// of series stays bounded. Requests without an endpoint are labeled "none".
+//
+// Example:
+//
+//    metrics := micro.NewMetrics()
+//    metrics.Listen(app.EventEmitter)
+//    app.Get("/metrics", metrics.Handler)
+type Metrics struct {
+	// Buckets are the upper bounds of the request duration histogram,
+	// they must not be changed once requests have been observed
+	Buckets    []float64
+	mutex      sync.Mutex
+	requests   map[requestLabels]int
+	durations  map[requestLabels]*histogram
+	sizes      map[requestLabels]*summary
+	inFlight   int
+	collectors []Collector
+}
+
+type requestLabels struct {
+	route, method string
+	code          int
+}
+
+type histogram struct {
+	counts []int
+	sum    float64
+	count  int
+}
+
+type summary struct {
+	sum   float64
+	count int
+}
+
+// NewMetrics returns a new Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets:   DefaultBuckets,
+		requests:  map[requestLabels]int{},
+		durations: map[requestLabels]*histogram{},
+		sizes:     map[requestLabels]*summary{},
+	}
+}
+
+// Metrics collects the metrics of the application and serves them at path
+func (e *Micro) Metrics(path string) *Metrics {
+	metrics := NewMetrics()
+	metrics.Listen(e.EventEmitter)
+	e.Get(path, metrics.Handler).SetAttribute(OpenAPIIgnore, true)
+	return metrics
+}
+
+// Listen collects metrics from the request lifecycle events emitted on emitter
+func (metrics *Metrics) Listen(emitter *EventEmitter) {
+	emitter.On(EventRequestStart, func(event string, arguments ...interface{}) bool {
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+		metrics.inFlight++
+		return true
+	})
+	emitter.On(EventRequestEnd, func(event string, arguments ...interface{}) bool {
+		if end, ok := arguments[0].(RequestEndEvent); ok {
+			metrics.observe(end)
+		}
+		return true
+	})
+}
+
+// Register adds a custom collector whose metrics are served along request metrics
+func (metrics *Metrics) Register(collector Collector) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.collectors = append(metrics.collectors, collector)
+}
+
+// Handler writes the metrics in the Prometheus text exposition format
+func (metrics *Metrics) Handler(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Must(metrics.Collect(rw))
+}
+
+// Collect writes the metrics in the Prometheus text exposition format.
+// The metrics are written to w once collected so that a slow client does not block requests.
+func (metrics *Metrics) Collect(w io.Writer) error {
+	buffer := new(bytes.Buffer)
+	metrics.mutex.Lock()
+	fmt.Fprintln(buffer, "# HELP micro_http_requests_in_flight Number of requests being handled.")
+	fmt.Fprintln(buffer, "# TYPE micro_http_requests_in_flight gauge")
+	fmt.Fprintf(buffer, "micro_http_requests_in_flight %d\n", metrics.inFlight)
+
+	fmt.Fprintln(buffer, "# HELP micro_http_requests_total Number of requests by route, method and status code.")
+	fmt.Fprintln(buffer, "# TYPE micro_http_requests_total counter")
+	for _, labels := range sortedLabels(metrics.requests) {
+		fmt.Fprintf(buffer, "micro_http_requests_total{route=%s,method=%s,code=\"%d\"} %d\n",
+			quoteLabel(labels.route), quoteLabel(labels.method), labels.code, metrics.requests[labels])
+	}
+
+	fmt.Fprintln(buffer, "# HELP micro_http_request_duration_seconds Request latency by route and method.")
+	fmt.Fprintln(buffer, "# TYPE micro_http_request_duration_seconds histogram")
+	for _, labels := range sortedLabels(metrics.durations) {
+		h, prefix := metrics.durations[labels], routeLabels(labels)
+		for i, bound := range metrics.Buckets {
+			fmt.Fprintf(buffer, "micro_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				prefix, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(buffer, "micro_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", prefix, h.count)
+		fmt.Fprintf(buffer, "micro_http_request_duration_seconds_sum{%s} %s\n", prefix, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(buffer, "micro_http_request_duration_seconds_count{%s} %d\n", prefix, h.count)
+	}
+
+	fmt.Fprintln(buffer, "# HELP micro_http_response_size_bytes Response size by route and method.")
+	fmt.Fprintln(buffer, "# TYPE micro_http_response_size_bytes summary")
+	for _, labels := range sortedLabels(metrics.sizes) {
+		s, prefix := metrics.sizes[labels], routeLabels(labels)
+		fmt.Fprintf(buffer, "micro_http_response_size_bytes_sum{%s} %s\n", prefix, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(buffer, "micro_http_response_size_bytes_count{%s} %d\n", prefix, s.count)
+	}
+	collectors := metrics.collectors
+	metrics.mutex.Unlock()
+
+	for _, collector := range collectors {
+		if err := collector.Collect(buffer); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+func (metrics *Metrics) observe(end RequestEndEvent) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.inFlight--
+	labels := requestLabels{route: "none", method: normalizeMethod(end.Request.Method), code: end.Status}
+	// passthrough routes such as middlewares do not label requests
+	if end.Endpoint != nil {
+		labels.route = end.Endpoint.Name()
+	}
+	metrics.requests[labels]++
+	// durations and sizes are not labeled by status code
+	labels.code = 0
+	h := metrics.durations[labels]
+	if h == nil {
+		h = &histogram{counts: make([]int, len(metrics.Buckets))}
+		metrics.durations[labels] = h
+	}
+	seconds := end.Duration.Seconds()
+	for i, bound := range metrics.Buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+	s := metrics.sizes[labels]
+	if s == nil {
+		s = &summary{}
+		metrics.sizes[labels] = s
+	}
+	s.sum += float64(end.Length)
+	s.count++
+}
+
+// normalizeMethod bounds the values of the method label
+func normalizeMethod(method string) string {
+	switch method = strings.ToUpper(method); method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+func routeLabels(labels requestLabels) string {
+	return "route=" + quoteLabel(labels.route) + ",method=" + quoteLabel(labels.method)
+}
+
+// quoteLabel quotes a label value as required by the text exposition format
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func sortedLabels[V any](m map[requestLabels]V) []requestLabels {
+	labels := make([]requestLabels, 0, len(m))
+	for l := range m {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].route != labels[j].route {
+			return labels[i].route < labels[j].route
+		}
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].code < labels[j].code
+	})
+	return labels
+}
@@ -20,13 +20,17 @@ package micro
 import (
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"reflect"
 	"regexp"
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 var (
@@ -36,6 +40,10 @@ var (
 	DefaultParamPattern = "(\\w+)"
 )
 
+// CallsNextAttribute marks a route whose handler calls next on purpose when set to true,
+// Boot does not report the routes it shadows as unreachable
+const CallsNextAttribute = "route.callsnext"
+
 /**********************************/
 /*               APP              */
 /**********************************/
@@ -46,9 +54,30 @@ type Micro struct {
 	*ControllerCollection
 	*EventEmitter
 	RequestMatcher *RequestMatcher
-	booted         bool
-	injector       *Injector
-	errorHandlers  map[int]HandlerFunction
+	// MaxBodyBytes is the maximum size of request bodies, 0 means no limit.
+	// The MaxBodyBytesAttribute attribute of a route replaces it.
+	MaxBodyBytes int64
+	// TimeoutStatus is the status of requests whose TimeoutAttribute expired,
+	// 503 by default, 504 suits applications waiting for upstream services
+	TimeoutStatus int
+	// Renderer renders the templates of Context.Render
+	Renderer Renderer
+	// BufferResponses holds responses until handlers return, so that after an error
+	// or a panic, the error handler replaces the response written so far
+	BufferResponses bool
+	// BufferThreshold is the size in bytes after which a buffered response is written
+	// and no longer buffered, 0 means no limit. Handlers can also stream a buffered
+	// response by flushing it with http.ResponseController .
+	BufferThreshold int
+	// ShutdownDelay is how long Shutdown reports the application as not ready
+	// before stopping the servers, so that load balancers stop sending requests
+	ShutdownDelay   time.Duration
+	booted          bool
+	bootErr         error
+	injector        *Injector
+	health          *HealthRegistry
+	errorHandlers   map[int]HandlerFunction
+	requestServices []interface{}
 }
 
 // New creates an micro application
@@ -57,18 +86,49 @@ func New() *Micro {
 		ControllerCollection: NewControllerCollection(),
 		EventEmitter:         NewEventEmitter(),
 		injector:             NewInjector(),
+		health:               NewHealthRegistry(),
 		errorHandlers:        map[int]HandlerFunction{},
+		TimeoutStatus:        http.StatusServiceUnavailable,
 	}
 	micro.injector.Register(micro)
+	micro.injector.Register(NewHTTPClient(nil))
+	micro.injector.Register(micro.health)
 	return micro
 }
 
-// Boot boots the application
-func (e *Micro) Boot() {
-	if !e.Booted() {
-		e.ControllerCollection.Flush()
-		e.booted = true
+// Boot boots the application : it freezes all routes then validates
+// the application graph. Boot returns an error listing every problem found :
+//
+//   - a route or an error handler has arguments that cannot be resolved
+//     by the injector or by the request injector
+//   - two routes share the same name
+//   - a route pattern does not compile or has more groups than route variables
+//   - a route is unreachable because an earlier non passthrough route shadows it,
+//     unless the earlier route has the CallsNextAttribute attribute
+//
+// The application is booted even if problems are found. Calling Boot
+// on a booted application returns the problems found by the first call.
+func (e *Micro) Boot() error {
+	if e.Booted() {
+		return e.bootErr
 	}
+	if e.errorHandlers[500] == nil {
+		e.Error(500, InternalServerErrorHandler)
+	}
+	if e.errorHandlers[404] == nil {
+		e.Error(404, NotFoundErrorHandler)
+	}
+	e.ControllerCollection.Flush()
+	e.booted = true
+	e.bootErr = e.validate()
+	return e.bootErr
+}
+
+// MustBoot is the "panicable" version of Boot
+//
+// Can Panic!
+func (e *Micro) MustBoot() {
+	Must(e.Boot())
 }
 
 // Booted returns true if the Boot function has been called
@@ -80,44 +140,86 @@ func (e Micro) Booted() bool {
 //
 // Can Panic!
 func (e *Micro) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	if !e.Booted() {
+		if err := e.Boot(); err != nil {
+			log.Println(err)
+		}
+	}
+	if e.RequestMatcher == nil {
+		e.RequestMatcher = NewRequestMatcher(e.ControllerCollection)
+	}
+	// find all routes matching the request in the route collection
+	matches := e.RequestMatcher.MatchAll(request)
+	if endpoint := endpointOf(matches); endpoint != nil {
+		if timeout, ok := endpoint.Attribute(TimeoutAttribute).(time.Duration); ok && timeout > 0 {
+			e.serveWithTimeout(responseWriter, request, matches, timeout)
+			return
+		}
+	}
+	e.serve(responseWriter, request, matches)
+}
+
+// serve handles a request with the routes matching it
+func (e *Micro) serve(responseWriter http.ResponseWriter, request *http.Request, matches []*Route) {
 	var (
-		matches                []*Route
 		next                   Next
 		context                *Context
 		requestInjector        *Injector
 		responseWriterWithCode *ResponseWriterWithCode
+		buffer                 *responseBuffer
+		route                  *Route
+		start                  = time.Now()
 	)
+	// wrap responseWriter so we can access the status code
+	responseWriterWithCode = &ResponseWriterWithCode{
+		ResponseWriter: responseWriter,
+	}
+	if e.BufferResponses {
+		buffer = newResponseBuffer(responseWriter, e.BufferThreshold)
+		responseWriterWithCode.ResponseWriter = buffer
+	}
 	defer func() {
 		if err := recover(); err != nil {
-			responseWriter.WriteHeader(http.StatusInternalServerError)
-			log.Println(err)
-			debug.PrintStack()
+			stack := debug.Stack()
+			responseWriterWithCode.discard()
+			responseWriterWithCode.WriteHeader(http.StatusInternalServerError)
+			if id := context.RequestID(); id != "" {
+				log.Println("request", id, ":", err)
+			} else {
+				log.Println(err)
+			}
+			log.Printf("%s", stack)
+			e.Emit(EventPanic, PanicEvent{Request: context.Request, Route: route, Error: err, Stack: stack})
 			requestInjector.MustApply(e.errorHandlers[500])
 		}
+		if buffer != nil {
+			buffer.commit()
+		}
+		status, length := responseWriterWithCode.Code(), responseWriterWithCode.Length()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if timeout, ok := responseWriter.(*timeoutWriter); ok {
+			status, length = timeout.result(status, length)
+		}
+		e.Emit(EventRequestEnd, RequestEndEvent{
+			Request:  context.Request,
+			Route:    route,
+			Endpoint: context.Endpoint(),
+			Status:   status,
+			Length:   length,
+			Duration: time.Since(start),
+		})
 	}()
-	// wrap responseWriter so we can access the status code
-	responseWriterWithCode = &ResponseWriterWithCode{
-		ResponseWriter: responseWriter,
-	}
 	// sets context and injector
 	context = NewContext(responseWriterWithCode, request)
-	requestInjector = NewInjector(request, responseWriterWithCode, context, e.EventEmitter)
-	requestInjector.Register(requestInjector)
-	requestInjector.SetParent(e.Injector())
-	if e.errorHandlers[500] == nil {
-		e.Error(500, InternalServerErrorHandler)
-	}
-	if e.errorHandlers[404] == nil {
-		e.Error(404, NotFoundErrorHandler)
-	}
-	if e.RequestMatcher == nil {
-		e.RequestMatcher = NewRequestMatcher(e.ControllerCollection)
-	}
-	if !e.Booted() {
-		e.Boot()
-	}
-	// find all routes matching the request in the route collection
-	matches = e.RequestMatcher.MatchAll(request)
+	context.app = e
+	defer context.cleanup()
+	requestInjector = e.newRequestInjector(responseWriterWithCode, request, context)
+	e.Emit(EventRequestStart, RequestStartEvent{Request: request, Context: context})
+	context.matches = matches
+	// apply the body size limit and the read timeout of the endpoint
+	e.limit(responseWriterWithCode, context)
 
 	// For the first matched route, call all its handlers
 	// if an handler in a route calls micro.Next next() , execute the next handler
@@ -125,7 +227,8 @@ func (e *Micro) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 	// if there are still some matched routes and the last handler of the previous route calls next
 	// then repeat the process for the next matched route
 	next = func() {
-		if e.hasErrorCode(responseWriterWithCode, requestInjector) {
+		e.timedOut(responseWriterWithCode, context)
+		if e.hasErrorCode(responseWriterWithCode, request, requestInjector) {
 			return
 		}
 		if len(matches) == 0 {
@@ -134,17 +237,34 @@ func (e *Micro) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 		}
 		match := matches[0]
 		matches = matches[1:]
+		route = match
+		context.route = match
 		// If there are some request variables, populate the context with them
 		for i, matchedParam := range match.pattern.FindStringSubmatch(request.URL.Path)[1:] {
 			context.RequestVars[match.params[i]] = matchedParam
 		}
+		// matchers such as the host matcher can also extract request variables
+		for _, matcher := range match.matchers {
+			if varsMatcher, ok := matcher.(VarsMatcher); ok {
+				for key, value := range varsMatcher.RequestVars(request) {
+					context.RequestVars[key] = value
+				}
+			}
+		}
 
+		e.Emit(EventRouteMatched, RouteMatchedEvent{Request: request, Route: match})
+
+		requestInjector.Register(match)
 		requestInjector.Register(next)
 		context.next = next
+		e.Emit(EventHandlerBefore, HandlerBeforeEvent{Request: request, Route: match})
 		requestInjector.MustApply(match.Handler())
+		e.Emit(EventHandlerAfter, HandlerAfterEvent{Request: request, Route: match})
 	}
 	next()
-
+	if e.timedOut(responseWriterWithCode, context) {
+		e.hasErrorCode(responseWriterWithCode, request, requestInjector)
+	}
 }
 
 // Error sets an error handler given an error code.
@@ -162,8 +282,13 @@ func (e *Micro) Error(errorCode int, handlerFunc HandlerFunction) {
 }
 
 // hasErrorCode Return true if a http status greater than 399 has been set
-func (e *Micro) hasErrorCode(rw *ResponseWriterWithCode, injector *Injector) bool {
+func (e *Micro) hasErrorCode(rw *ResponseWriterWithCode, request *http.Request, injector *Injector) bool {
 	if code := rw.Code(); code > 399 {
+		e.Emit(EventStatusError, StatusErrorEvent{Request: request, Status: code})
+		// a buffered response is replaced by the error response
+		if rw.discard() {
+			rw.WriteHeader(code)
+		}
 		if e.errorHandlers[code] != nil && rw.Length() == 0 {
 			injector.MustApply(e.errorHandlers[code])
 		} else {
@@ -179,6 +304,94 @@ func (e *Micro) Injector() *Injector {
 	return e.injector
 }
 
+// DeclareRequestService declares that a service of the same type as Type will
+// be registered in the request injector by a middleware, so Boot
+// does not report handlers depending on that service as unresolvable.
+//
+// Example:
+//
+//    app.DeclareRequestService((*User)(nil))
+func (e *Micro) DeclareRequestService(Type interface{}) {
+	e.requestServices = append(e.requestServices, Type)
+}
+
+// newRequestInjector returns the injector used during a request.
+// It is also used by Boot with nil values in order to know which
+// types can be resolved during a request.
+func (e *Micro) newRequestInjector(rw *ResponseWriterWithCode, request *http.Request, context *Context) *Injector {
+	requestInjector := NewInjector(request, rw, context, e.EventEmitter)
+	requestInjector.Register(requestInjector)
+	requestInjector.SetParent(e.Injector())
+	return requestInjector
+}
+
+// validate returns an error listing all the problems found in the application graph
+func (e *Micro) validate() error {
+	var (
+		errs            []error
+		names           = map[string]*Route{}
+		requestInjector = e.newRequestInjector(nil, nil, nil)
+	)
+	requestInjector.Register(Next(nil))
+	requestInjector.Register((*Route)(nil))
+	for _, service := range e.requestServices {
+		requestInjector.Register(service)
+	}
+	for code, handler := range e.errorHandlers {
+		if err := canApply(requestInjector, handler); err != nil {
+			errs = append(errs, fmt.Errorf("error handler %d : %s", code, err))
+		}
+	}
+	for i, route := range e.Routes {
+		if err := canApply(requestInjector, route.Handler()); err != nil {
+			errs = append(errs, fmt.Errorf("route %s : %s", route.Name(), err))
+		}
+		if route.err != nil {
+			errs = append(errs, fmt.Errorf("route %s : %s", route.Name(), route.err))
+		} else if route.pattern.NumSubexp() != len(route.Params()) {
+			errs = append(errs, fmt.Errorf("route %s : pattern %s has %d groups for %d route variables, use non capturing groups (?:...) in assertions",
+				route.Name(), route.pattern, route.pattern.NumSubexp(), len(route.Params())))
+		}
+		// generated names are allowed to be the same, routes with the same path
+		// can differ by their matchers
+		if route.Name() != route.defaultName() {
+			if other, ok := names[route.Name()]; ok {
+				errs = append(errs, fmt.Errorf("route %s : name already used by route with path %s", route.Name(), other.path))
+			} else {
+				names[route.Name()] = route
+			}
+		}
+		if _, _, _, err := accessRules(route); err != nil {
+			errs = append(errs, fmt.Errorf("route %s : %s", route.Name(), err))
+		}
+		for _, previous := range e.Routes[:i] {
+			if previous.shadows(route) {
+				errs = append(errs, fmt.Errorf("route %s : unreachable, shadowed by route %s", route.Name(), previous.Name()))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// canApply returns an error if function is not callable or if one of its
+// arguments cannot be resolved by the injector
+func canApply(injector *Injector, function interface{}) error {
+	if !IsCallable(function) {
+		return fmt.Errorf("%v is not a function or a method", function)
+	}
+	functionType := reflect.TypeOf(function)
+	if functionType.Kind() == reflect.Ptr {
+		functionType = functionType.Elem()
+	}
+	for j := 0; j < functionType.NumIn(); j++ {
+		if _, err := injector.Resolve(functionType.In(j)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 /**********************************/
 /*     DEFAULT ERROR HANDLERS     */
 /**********************************/
@@ -204,8 +417,15 @@ type Context struct {
 	// RequestVars are variables extracted from the request
 	RequestVars          map[string]string
 	//  Vars is a map to store any data during the request response cycle
-	Vars map[string]interface{}
-	next Next
+	Vars    map[string]interface{}
+	next    Next
+	route   *Route
+	matches []*Route
+	app     *Micro
+	// uploads are the files of a multipart request, uploadErr the error reading them
+	uploads   map[string][]*UploadedFile
+	uploadErr error
+	cleanups  []func()
 }
 
 // NewContext returns a new Context
@@ -219,6 +439,30 @@ func NewContext(response http.ResponseWriter, request *http.Request) *Context {
 	return ctx
 }
 
+// Route returns the route handling the request, once next returns
+// it is the last route the request went through.
+// It is nil if no route matched the request.
+func (ctx *Context) Route() *Route {
+	return ctx.route
+}
+
+// Endpoint returns the first matched route that is not a passthrough route,
+// the route expected to handle the request, or nil if there is none.
+// Middlewares can read its attributes before calling next.
+func (ctx *Context) Endpoint() *Route {
+	return endpointOf(ctx.matches)
+}
+
+// endpointOf returns the first route of matches that is not a passthrough route
+func endpointOf(matches []*Route) *Route {
+	for _, route := range matches {
+		if !route.IsPassthrough() {
+			return route
+		}
+	}
+	return nil
+}
+
 // Next calls the next middleware in the middleware chain
 func (ctx *Context) Next() {
 	ctx.next()
@@ -257,14 +501,18 @@ func (ctx *Context) WriteJSONP(v interface{}, callbackName string) (n int, err e
 	return
 }
 
-// ReadJSON reads json from request's Body
+// ReadJSON reads json from request's Body.
+// If the body is too large, the 413 status is set and an *http.MaxBytesError is returned,
+// calling Next then executes the 413 error handler.
 func (ctx *Context) ReadJSON(v interface{}) error {
-	return json.NewDecoder(ctx.Request.Body).Decode(v)
+	return ctx.bodyError(json.NewDecoder(ctx.Request.Body).Decode(v))
 }
 
-// ReadXML reads xml from request's body
+// ReadXML reads xml from request's body.
+// If the body is too large, the 413 status is set and an *http.MaxBytesError is returned,
+// calling Next then executes the 413 error handler.
 func (ctx *Context) ReadXML(v interface{}) error {
-	return xml.NewDecoder(ctx.Request.Body).Decode(v)
+	return ctx.bodyError(xml.NewDecoder(ctx.Request.Body).Decode(v))
 }
 
 /**********************************/
@@ -288,7 +536,14 @@ type Route struct {
 	name string
 	// wether the route is intended to be a middlware or not
 	passthrough bool
-	matchers    []Matcher
+	// matchers a request must match, custom matchers are
+	// appended to the pattern and method matchers when the route is frozen
+	matchers []Matcher
+	host     string
+	// hostMatcher is the matcher of host, replaced when the host changes
+	hostMatcher *HostMatcher
+	// err is set when the route pattern cannot be compiled
+	err error
 }
 
 // NewRoute creates a new route with a path that handles all methods
@@ -405,19 +660,53 @@ func (r *Route) freeze() *Route {
 	if !r.passthrough {
 		stringPattern = stringPattern + "$"
 	}
-	r.pattern = regexp.MustCompile(stringPattern)
 	if r.name == "" {
-		r.name = regexp.MustCompile("\\W+").ReplaceAllString(r.path+"_"+fmt.Sprint(r.methods), "_")
+		r.name = r.defaultName()
 	}
-	r.matchers = []Matcher{
+	r.frozen = true
+	// an invalid pattern is reported by Micro.Boot, the route will never match
+	if r.pattern, r.err = regexp.Compile(stringPattern); r.err != nil {
+		return r
+	}
+	r.matchers = append([]Matcher{
 		NewPatternMatcher(r.pattern),
 		NewMethodMatcher(r.Methods()...),
-	}
-	r.frozen = true
+	}, r.matchers...)
 
 	return r
 }
 
+// defaultName returns the name given to a route that has not been named
+func (r *Route) defaultName() string {
+	return regexp.MustCompile("\\W+").ReplaceAllString(r.host+r.path+"_"+fmt.Sprint(r.methods), "_")
+}
+
+// shadows returns true if the route is not a passthrough route and matches
+// every request other would match, so other is never reached
+// unless the route handler calls next.
+// Only routes with the same pattern or static routes are detected.
+func (r *Route) shadows(other *Route) bool {
+	// a route with custom matchers does not match every request
+	if r.passthrough || r.Attribute(CallsNextAttribute) == true || r.pattern == nil || other.pattern == nil || len(r.matchers) > 2 {
+		return false
+	}
+	if len(r.methods) > 0 {
+		if len(other.methods) == 0 {
+			return false
+		}
+		methodMatcher := NewMethodMatcher(r.methods...)
+		for _, method := range other.methods {
+			if !methodMatcher.Match(&http.Request{Method: method}) {
+				return false
+			}
+		}
+	}
+	if r.pattern.String() == other.pattern.String() {
+		return true
+	}
+	return len(other.params) == 0 && regexp.QuoteMeta(other.path) == other.path && r.pattern.MatchString(other.path)
+}
+
 // IsFrozen return the frozen state of a route.
 // A Frozen route cannot be modified.
 func (r *Route) IsFrozen() bool {
@@ -456,6 +745,12 @@ func (r *Route) Assert(parameterName string, pattern string) *Route {
 	return r
 }
 
+// Assertion returns the pattern a route variable is asserted to respect,
+// or an empty string if the route variable has no assertion
+func (r *Route) Assertion(parameterName string) string {
+	return r.assertions[parameterName]
+}
+
 // SetAttribute sets a route attribute
 func (r *Route) SetAttribute(attr string, value interface{}) *Route {
 	r.attributes[attr] = value
@@ -467,17 +762,113 @@ func (r *Route) Attribute(attr string) interface{} {
 	return r.attributes[attr]
 }
 
+// Attributes returns all route attributes
+func (r *Route) Attributes() map[string]interface{} {
+	return r.attributes
+}
+
+// Path returns the route path, prefixed with the path of the collection
+// the route is mounted on once the route is frozen
+func (r *Route) Path() string {
+	return r.path
+}
+
+// Pattern returns the regexp a request path is matched against,
+// or nil if the route is not frozen
+func (r *Route) Pattern() *regexp.Regexp {
+	return r.pattern
+}
+
+// AddMatcher adds a custom matcher a request must match
+// for the route to handle it.
+func (r *Route) AddMatcher(matcher Matcher) *Route {
+	if r.IsFrozen() {
+		return r
+	}
+	r.matchers = append(r.matchers, matcher)
+	return r
+}
+
+// Host restricts the route to requests whose host matches pattern.
+// Host variables between braces are extracted into the context RequestVars.
+// Calling Host again replaces the pattern.
+//
+// Example:
+//
+//    route.Host("{tenant}.example.com")
+//
+// Can Panic! if the pattern is not valid.
+func (r *Route) Host(pattern string) *Route {
+	if r.IsFrozen() {
+		return r
+	}
+	matcher := NewHostMatcher(pattern)
+	r.host = pattern
+	for i, previous := range r.matchers {
+		if previous == Matcher(r.hostMatcher) {
+			r.matchers[i], r.hostMatcher = matcher, matcher
+			return r
+		}
+	}
+	r.hostMatcher = matcher
+	return r.AddMatcher(matcher)
+}
+
+// Headers restricts the route to requests having the given headers.
+// pairs are header names followed by header values, an empty value
+// only requires the header to be present.
+//
+// Example:
+//
+//    route.Headers("X-Api-Version", "2")
+//
+// Can Panic! if the number of pairs is odd.
+func (r *Route) Headers(pairs ...string) *Route {
+	return r.AddMatcher(NewHeaderMatcher(pairs...))
+}
+
+// Queries restricts the route to requests having the given query parameters,
+// see Route.Headers .
+//
+// Can Panic! if the number of pairs is odd.
+func (r *Route) Queries(pairs ...string) *Route {
+	return r.AddMatcher(NewQueryMatcher(pairs...))
+}
+
+// Schemes restricts the route to requests using one of the schemes
+func (r *Route) Schemes(schemes ...string) *Route {
+	return r.AddMatcher(NewSchemeMatcher(schemes...))
+}
+
+// Consumes restricts the route to requests whose content type is one of mediaTypes.
+// A media type can end with a wildcard such as text/* .
+func (r *Route) Consumes(mediaTypes ...string) *Route {
+	return r.AddMatcher(NewContentTypeMatcher(mediaTypes...))
+}
+
+// HostPattern returns the host pattern of the route
+func (r *Route) HostPattern() string {
+	return r.host
+}
+
+// IsPassthrough returns true if the route is intended to be a middleware
+func (r *Route) IsPassthrough() bool {
+	return r.passthrough
+}
+
 /**********************************/
 /*   CONTROLLER COLLECTION             */
 /**********************************/
 
 // ControllerCollection is a collection of routes
 type ControllerCollection struct {
-	Routes    []*Route
-	prefix    string
-	frozen    bool
-	Children  []*ControllerCollection
-	hasParent bool
+	Routes     []*Route
+	prefix     string
+	frozen     bool
+	Children   []*ControllerCollection
+	hasParent  bool
+	host       string
+	attributes map[string]interface{}
 }
 
 // NewControllerCollection creates a new ControllerCollection
@@ -521,12 +912,28 @@ func (rc *ControllerCollection) Flush() {
 
 	for _, route := range rc.Routes {
 		route.path = rc.prefix + route.path
+		if rc.host != "" && route.host == "" {
+			route.Host(rc.host)
+		}
+		for attr, value := range rc.attributes {
+			if _, ok := route.attributes[attr]; !ok {
+				route.SetAttribute(attr, value)
+			}
+		}
 		route.freeze()
 	}
 
 	if len(rc.Children) > 0 {
 
 		for _, routeCollection := range rc.Children {
+			if routeCollection.host == "" {
+				routeCollection.host = rc.host
+			}
+			for attr, value := range rc.attributes {
+				if _, ok := routeCollection.attributes[attr]; !ok {
+					routeCollection.SetAttribute(attr, value)
+				}
+			}
 			routeCollection.setPrefix(rc.prefix + routeCollection.prefix).Flush()
 			for _, route := range routeCollection.Routes {
 				rc.Routes = append(rc.Routes, route)
@@ -549,6 +956,26 @@ func (rc *ControllerCollection) Use(path string, handlerFunction HandlerFunction
 	return route
 }
 
+// Host restricts all routes of the collection and of its mounted collections
+// to requests whose host matches pattern, see Route.Host .
+func (rc *ControllerCollection) Host(pattern string) *ControllerCollection {
+	rc.mustNotBeFrozen()
+	NewHostMatcher(pattern)
+	rc.host = pattern
+	return rc
+}
+
+// SetAttribute sets an attribute on all routes of the collection and of its mounted
+// collections, unless a route or a mounted collection sets the same attribute.
+func (rc *ControllerCollection) SetAttribute(attr string, value interface{}) *ControllerCollection {
+	rc.mustNotBeFrozen()
+	if rc.attributes == nil {
+		rc.attributes = map[string]interface{}{}
+	}
+	rc.attributes[attr] = value
+	return rc
+}
+
 // Mount mounts a route collection on a path. All routes in the route collection will be prefixed
 // with that path.
 func (rc *ControllerCollection) Mount(path string, routeCollection *ControllerCollection) *ControllerCollection {
@@ -607,6 +1034,12 @@ type Matcher interface {
 	Match(*http.Request) bool
 }
 
+// VarsMatcher is a Matcher that extracts request variables from the request it matches
+type VarsMatcher interface {
+	Matcher
+	RequestVars(*http.Request) map[string]string
+}
+
 // RequestMatcher match request path to route pattern
 type RequestMatcher struct {
 	routeCollection *ControllerCollection
@@ -621,6 +1054,9 @@ func NewRequestMatcher(routeCollection *ControllerCollection) *RequestMatcher {
 func (rm *RequestMatcher) MatchAll(request *http.Request) (matches []*Route) {
 	if len(rm.routeCollection.Routes) > 0 {
 		for _, route := range rm.routeCollection.Routes {
+			if route.pattern == nil {
+				continue
+			}
 			match := true
 			for _, matcher := range route.matchers {
 				if !matcher.Match(request) {
@@ -638,85 +1074,6 @@ func (rm *RequestMatcher) MatchAll(request *http.Request) (matches []*Route) {
 
 
 
-/**********************************/
-/*         EVENT EMITTER          */
-/**********************************/
-
-// Listener is an event handler function
-type Listener *func(string, ...interface{}) bool
-
-// EventEmitter listens for and emits events
-type EventEmitter struct {
-	handlers map[string][]Listener
-}
-
-// NewEventEmitter returns a new event emitter
-func NewEventEmitter() *EventEmitter {
-	return &EventEmitter{
-		handlers: map[string][]Listener{},
-	}
-}
-
-// Emit emits an event
-func (em *EventEmitter) Emit(event string, arguments ...interface{}) {
-	if len(em.handlers) > 0 && em.handlers[event] != nil {
-		for _, handler := range em.handlers[event] {
-			Continue := (*handler)(event, arguments...)
-			if !Continue {
-				break
-			}
-		}
-	}
-}
-
-// AddListener adds a new listener function pointer
-func (em *EventEmitter) AddListener(event string, listener Listener) {
-	if em.handlers[event] != nil {
-		em.handlers[event] = []Listener{}
-	}
-	em.handlers[event] = append(em.handlers[event], listener)
-}
-
-// RemoveListener removes a listener function pointer
-func (em *EventEmitter) RemoveListener(event string, listener Listener) bool {
-	var found bool
-	if em.handlers[event] != nil {
-		for i, handler := range em.handlers[event] {
-			if handler == listener {
-
-				head := em.handlers[event][0:i]
-				if length := len(em.handlers); i == length-1 {
-					em.handlers[event] = head
-				} else {
-					tail := em.handlers[event][i+1 : length-1]
-					em.handlers[event] = append(head, tail...)
-				}
-
-				found = true
-				break
-			}
-		}
-	}
-	return found
-}
-
-// RemoveAllListeners remove all listeners given an event and returns the listener slice
-func (em *EventEmitter) RemoveAllListeners(event string) []Listener {
-	listeners := []Listener{}
-	if em.handlers[event] != nil {
-		listeners, em.handlers[event] = em.handlers[event], listeners
-	}
-	return listeners
-}
-
-// HasListener returns true if an event has listeners
-func (em *EventEmitter) HasListener(event string) bool {
-	if em.handlers[event] != nil && len(em.handlers[event]) > 0 {
-		return true
-	}
-	return false
-}
-
 /**********************************/
 /*              UTILS             */
 /**********************************/
@@ -763,16 +1120,20 @@ type ResponseWriterWithCode struct {
 	http.ResponseWriter
 	code          int
 	writtenLength int
+	headerWritten bool
+	hooks         []func()
 }
 
 // WriteHeader sends an HTTP response header with status code.
 func (r *ResponseWriterWithCode) WriteHeader(code int) {
+	r.beforeWriteHeader()
 	r.code = code
 	r.ResponseWriter.WriteHeader(code)
 }
 
 // Write writes to the response
 func (r *ResponseWriterWithCode) Write(b []byte) (int, error) {
+	r.beforeWriteHeader()
 	i, err := r.ResponseWriter.Write(b)
 	r.writtenLength = r.writtenLength + len(b)
 	return i, err
@@ -788,6 +1149,32 @@ func (r *ResponseWriterWithCode) Length() int {
 	return r.writtenLength
 }
 
+// BeforeWriteHeader adds a function called once before the response header
+// is written, so that it can still set headers or cookies.
+func (r *ResponseWriterWithCode) BeforeWriteHeader(hook func()) {
+	r.hooks = append(r.hooks, hook)
+}
+
+// HeaderWritten returns true if the response header has been written
+func (r *ResponseWriterWithCode) HeaderWritten() bool {
+	return r.headerWritten
+}
+
+// Unwrap returns the wrapped ResponseWriter, so http.ResponseController can reach it
+func (r *ResponseWriterWithCode) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *ResponseWriterWithCode) beforeWriteHeader() {
+	if r.headerWritten {
+		return
+	}
+	r.headerWritten = true
+	for _, hook := range r.hooks {
+		hook()
+	}
+}
+
 // Next represents a function
 type Next func()
 
@@ -837,3 +1224,190 @@ func (patternMatcher PatternMatcher) Pattern() *regexp.Regexp {
 func (patternMatcher PatternMatcher) Match(request *http.Request) bool {
 	return patternMatcher.pattern.MatchString(request.URL.Path)
 }
+
+// HostMatcher matches a request by host
+type HostMatcher struct {
+	pattern *regexp.Regexp
+}
+
+// NewHostMatcher returns a new HostMatcher given a host pattern
+// such as {tenant}.example.com , a variable matches a host label
+// unless a pattern is given : {tenant:[a-z]+}.example.com
+//
+// Can Panic! if the pattern is not valid.
+func NewHostMatcher(pattern string) *HostMatcher {
+	var (
+		stringPattern string
+		last          int
+	)
+	for _, loc := range regexp.MustCompile("\\{(\\w+)(?::([^}]+))?\\}").FindAllStringSubmatchIndex(pattern, -1) {
+		variablePattern := "[^.]+"
+		if loc[4] != -1 {
+			variablePattern = pattern[loc[4]:loc[5]]
+		}
+		stringPattern = stringPattern + regexp.QuoteMeta(pattern[last:loc[0]]) + "(?P<" + pattern[loc[2]:loc[3]] + ">" + variablePattern + ")"
+		last = loc[1]
+	}
+	stringPattern = "(?i)^" + stringPattern + regexp.QuoteMeta(pattern[last:]) + "$"
+	return &HostMatcher{regexp.MustCompile(stringPattern)}
+}
+
+// Match returns true if the matcher matches the request host, port excluded
+func (hostMatcher HostMatcher) Match(request *http.Request) bool {
+	return hostMatcher.pattern.MatchString(requestHost(request))
+}
+
+// RequestVars returns the host variables of the request
+func (hostMatcher HostMatcher) RequestVars(request *http.Request) map[string]string {
+	vars := map[string]string{}
+	matches := hostMatcher.pattern.FindStringSubmatch(requestHost(request))
+	for i, name := range hostMatcher.pattern.SubexpNames() {
+		if name != "" && i < len(matches) {
+			vars[name] = matches[i]
+		}
+	}
+	return vars
+}
+
+// requestHost returns the request host without port
+func requestHost(request *http.Request) string {
+	if host, _, err := net.SplitHostPort(request.Host); err == nil {
+		return host
+	}
+	return request.Host
+}
+
+// HeaderMatcher matches a request by headers
+type HeaderMatcher struct {
+	headers map[string]string
+}
+
+// NewHeaderMatcher returns a new HeaderMatcher given header names
+// followed by header values. An empty value matches any value.
+//
+// Can Panic! if the number of pairs is odd.
+func NewHeaderMatcher(pairs ...string) *HeaderMatcher {
+	return &HeaderMatcher{mustBePairs(pairs)}
+}
+
+// Match returns true if the request has all the headers of the matcher
+func (headerMatcher HeaderMatcher) Match(request *http.Request) bool {
+	for name, value := range headerMatcher.headers {
+		values, ok := request.Header[http.CanonicalHeaderKey(name)]
+		if !ok || !containsValue(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryMatcher matches a request by query parameters
+type QueryMatcher struct {
+	queries map[string]string
+}
+
+// NewQueryMatcher returns a new QueryMatcher given query parameter names
+// followed by query parameter values. An empty value matches any value.
+//
+// Can Panic! if the number of pairs is odd.
+func NewQueryMatcher(pairs ...string) *QueryMatcher {
+	return &QueryMatcher{mustBePairs(pairs)}
+}
+
+// Match returns true if the request has all the query parameters of the matcher
+func (queryMatcher QueryMatcher) Match(request *http.Request) bool {
+	query := request.URL.Query()
+	for name, value := range queryMatcher.queries {
+		values, ok := query[name]
+		if !ok || !containsValue(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemeMatcher matches a request by scheme
+type SchemeMatcher struct {
+	schemes []string
+}
+
+// NewSchemeMatcher returns a new SchemeMatcher
+func NewSchemeMatcher(schemes ...string) *SchemeMatcher {
+	return &SchemeMatcher{schemes}
+}
+
+// Match returns true if the request scheme is one of the matcher schemes.
+// Server requests have no scheme in their url, https is assumed
+// if the connection uses TLS.
+func (schemeMatcher SchemeMatcher) Match(request *http.Request) bool {
+	scheme := request.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+	}
+	for _, s := range schemeMatcher.schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentTypeMatcher matches a request by content type
+type ContentTypeMatcher struct {
+	mediaTypes []string
+}
+
+// NewContentTypeMatcher returns a new ContentTypeMatcher
+func NewContentTypeMatcher(mediaTypes ...string) *ContentTypeMatcher {
+	return &ContentTypeMatcher{mediaTypes}
+}
+
+// Match returns true if the request content type is one of the matcher media types
+func (contentTypeMatcher ContentTypeMatcher) Match(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return matchMediaType(contentTypeMatcher.mediaTypes, mediaType)
+}
+
+// matchMediaType returns true if mediaType is one of mediaTypes, type/* wildcards are supported
+func matchMediaType(mediaTypes []string, mediaType string) bool {
+	for _, m := range mediaTypes {
+		m = strings.ToLower(m)
+		if m == mediaType || m == "*/*" || (strings.HasSuffix(m, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(m, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// mustBePairs converts names followed by values to a map
+//
+// Can Panic! if the number of pairs is odd.
+func mustBePairs(pairs []string) map[string]string {
+	if len(pairs)%2 != 0 {
+		panic(fmt.Sprintf("%v must be names followed by values", pairs))
+	}
+	m := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		m[pairs[i]] = pairs[i+1]
+	}
+	return m
+}
+
+// containsValue returns true if value is empty or is one of values
+func containsValue(values []string, value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -19,14 +19,35 @@ package micro_test
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/interactiv/expect"
 	"github.com/interactiv/micro"
@@ -356,6 +377,270 @@ func TestPrefix(t *testing.T) {
 	e.Expect(body).ToEqual(message)
 }
 
+/**********************************/
+/*           BOOT TESTS           */
+/**********************************/
+
+func TestBoot(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", func(next micro.Next) { next() })
+	app.Use("/", func(ctx *micro.Context, next micro.Next) { next() })
+	app.Get("/movies/:id", func(ctx *micro.Context, rw http.ResponseWriter) {}).Assert("id", "\\d+")
+	app.Injector().Register(&Foo{})
+	app.DeclareRequestService(Person{})
+	app.Post("/movies", func(foo *Foo, caller Caller, person Person, r *http.Request) {})
+	e.Expect(app.Boot()).ToBeNil()
+	e.Expect(app.Booted()).ToBeTrue()
+}
+
+func TestBootErrors(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/foo", func(foo *Foo) {}).SetName("foo")
+	app.Get("/bar", func() {}).SetName("foo")
+	app.Get("/movies/:id", func() {}).Assert("id", "(\\d)+")
+	app.Get("/:page", func() {})
+	app.Get("/about", func() {})
+	app.Error(403, func(person Person) {})
+	err := app.Boot()
+	e.Expect(err).Not().ToBeNil()
+	e.Expect(err.Error()).ToContain("route foo : service with type *micro_test.Foo cannot be injected")
+	e.Expect(err.Error()).ToContain("route foo : name already used")
+	e.Expect(err.Error()).ToContain("has 2 groups for 1 route variables")
+	e.Expect(err.Error()).ToContain("route _about__GET_HEAD_ : unreachable, shadowed by route _page__GET_HEAD_")
+	e.Expect(err.Error()).ToContain("error handler 403")
+	e.Expect(app.Booted()).ToBeTrue()
+	e.Expect(func() {
+		app := micro.New()
+		app.Get("/", func(foo *Foo) {})
+		app.MustBoot()
+	}).ToPanic()
+	// later calls, including the ones of the introspection helpers, return the same problems
+	app.RouteTable()
+	e.Expect(app.Boot()).ToEqual(err)
+	app = micro.New()
+	app.Get("/:page", func(next micro.Next) { next() }).SetAttribute(micro.CallsNextAttribute, true)
+	app.Get("/about", func() {})
+	app.RouteTable()
+	e.Expect(app.Boot()).ToBeNil()
+}
+
+/**********************************/
+/*        ROUTE TABLE TESTS       */
+/**********************************/
+
+func TestRouteTable(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", func(next micro.Next) { next() })
+	app.Get("/movies/:id", func() {}).SetName("movie").SetAttribute("cache", true).
+		SetAttribute("policy", func(ctx *micro.Context) bool { return true })
+	table := app.RouteTable()
+	e.Expect(len(table)).ToBe(2)
+	e.Expect(table[0].Passthrough).ToBeTrue()
+	e.Expect(table[1].Name).ToBe("movie")
+	e.Expect(table[1].Methods).ToEqual([]string{"GET", "HEAD"})
+	e.Expect(table[1].Path).ToBe("/movies/:id")
+	e.Expect(table[1].Pattern).ToBe("^/movies/(\\w+)/?$")
+	e.Expect(table[1].Params).ToEqual([]string{"id"})
+	e.Expect(table[1].Attributes["cache"]).ToEqual(true)
+	buffer := new(bytes.Buffer)
+	e.Expect(app.PrintRoutes(buffer)).ToBeNil()
+	e.Expect(buffer.String()).ToContain("movie")
+	e.Expect(buffer.String()).ToContain("GET,HEAD")
+	buffer.Reset()
+	e.Expect(app.PrintRoutesJSON(buffer)).ToBeNil()
+	e.Expect(buffer.String()).ToContain(`"name": "movie"`)
+	e.Expect(buffer.String()).ToContain(`"policy": "0x`)
+	e.Expect(table[1].Attributes["policy"]).Not().ToBeNil()
+	// the table holds copies of the routing state
+	table[1].Attributes["cache"] = false
+	table[1].Methods[0] = "DELETE"
+	route := app.ControllerCollection.Routes[1]
+	e.Expect(route.Attribute("cache")).ToEqual(true)
+	e.Expect(route.Methods()[0]).ToBe("GET")
+}
+
+/**********************************/
+/*          OPENAPI TESTS         */
+/**********************************/
+
+func TestOpenAPIDocument(t *testing.T) {
+	type Audit struct {
+		Author  string `json:"author"`
+		Version int    `json:"version"`
+	}
+	type Location struct {
+		Path string `json:"path"`
+	}
+	type Route struct {
+		Name string `json:"name"`
+	}
+	type Movie struct {
+		Audit
+		*Location
+		Title   string   `json:"title"`
+		Year    int      `json:"year,omitempty"`
+		Actors  []string `json:"actors"`
+		Sequel  *Movie   `json:"sequel"`
+		Version string   `json:"version"`
+		Route   Route    `json:"route"`
+		Rule    micro.Route
+	}
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", func(next micro.Next) { next() })
+	app.Get("/movies/:id", func() {}).
+		Assert("id", "\\d+").
+		SetName("movie").
+		SetAttribute(micro.OpenAPISummary, "Find a movie").
+		SetAttribute(micro.OpenAPIResponse, Movie{})
+	movies := micro.NewControllerCollection()
+	movies.Post("/", func() {}).SetName("create_movie").SetAttribute(micro.OpenAPIRequest, &Movie{})
+	app.Mount("/movies", movies)
+	app.Get("/movies/:id", func() {}).Assert("id", "\\d+").Host("admin.example.com").SetName("admin_movie")
+	document, err := app.OpenAPIDocument(micro.OpenAPIInfo{Title: "Movies", Version: "1.0"})
+	e.Expect(err.Error()).ToBe("route admin_movie : operation GET /movies/{id} already described by route movie")
+	data, err := json.Marshal(document)
+	e.Expect(err).ToBeNil()
+	body := string(data)
+	e.Expect(body).ToContain(`"openapi":"3.0.3"`)
+	e.Expect(body).ToContain(`"/movies/{id}":{"get":{`)
+	e.Expect(body).ToContain(`"operationId":"movie"`)
+	e.Expect(body).ToContain(`"summary":"Find a movie"`)
+	e.Expect(body).ToContain(`"pattern":"^(\\d+)$"`)
+	e.Expect(body).ToContain(`"/movies":{"post":{`)
+	e.Expect(body).ToContain(`"$ref":"#/components/schemas/micro_test.Movie"`)
+	e.Expect(body).ToContain(`"required":["title","actors","version","route","Rule","author"]`)
+	// embedded structs are flattened, the fields of the outer struct take precedence
+	e.Expect(body).ToContain(`"author":{"type":"string"}`)
+	e.Expect(body).ToContain(`"path":{"type":"string"}`)
+	e.Expect(body).ToContain(`"version":{"type":"string"}`)
+	e.Expect(body).Not().ToContain(`"Audit"`)
+	// types with the same name from different packages are different components
+	e.Expect(body).ToContain(`"route":{"$ref":"#/components/schemas/micro_test.Route"}`)
+	e.Expect(body).ToContain(`"Rule":{"$ref":"#/components/schemas/micro.Route"}`)
+	e.Expect(body).Not().ToContain(`"head"`)
+}
+
+func TestOpenAPI(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/greet/:name", func() {}).SetAttribute(micro.OpenAPISummary, "Greets someone")
+	app.OpenAPI("/openapi.json", micro.OpenAPIInfo{Title: "Greetings", Version: "1.0"}, true)
+	server := httptest.NewServer(app)
+	defer server.Close()
+	for path, expected := range map[string]string{
+		"/openapi.json":      `"/greet/{name}"`,
+		"/openapi.json.yaml": `"/greet/{name}":`,
+		"/openapi.json.html": "Greets someone",
+	} {
+		res, err := http.Get(server.URL + path)
+		e.Expect(err).ToBeNil()
+		e.Expect(res.StatusCode).ToBe(200)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		e.Expect(string(body)).ToContain(expected)
+		e.Expect(string(body)).Not().ToContain("/openapi.json")
+	}
+}
+
+/**********************************/
+/*          MATCHER TESTS         */
+/**********************************/
+
+func TestHost(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/", func(ctx *micro.Context) {
+		ctx.WriteString("tenant ", ctx.RequestVars["tenant"])
+	}).Host("{tenant}.example.com")
+	admin := micro.NewControllerCollection().Host("admin.{domain:example\\.(?:com|org)}")
+	admin.Get("/users/:id", func(ctx *micro.Context) {
+		ctx.WriteString("admin ", ctx.RequestVars["domain"], " ", ctx.RequestVars["id"])
+	})
+	app.Mount("/", admin)
+	// the last host replaces the previous one
+	status := app.Get("/status", func(ctx *micro.Context) {
+		ctx.WriteString("status")
+	}).Host("status.example.org").Host("status.example.com")
+	app.Get("/", func(ctx *micro.Context) {
+		ctx.WriteString("default")
+	})
+	e.Expect(app.Boot()).ToBeNil()
+	e.Expect(status.HostPattern()).ToBe("status.example.com")
+	for host, expected := range map[string]string{
+		"status.example.com/status":  "status",
+		"status.example.org/status":  "404 page not found\n",
+		"acme.example.com":           "tenant acme",
+		"admin.example.org/users/10": "admin example.org 10",
+		"example.com":                "default",
+		"a.b.example.com":            "default",
+	} {
+		res := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://"+host, nil)
+		e.Expect(err).ToBeNil()
+		app.ServeHTTP(res, req)
+		e.Expect(res.Body.String()).ToBe(expected)
+	}
+}
+
+func TestAddMatcher(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/", func(ctx *micro.Context) {
+		ctx.WriteString("localhost")
+	}).AddMatcher(micro.NewHostMatcher("localhost"))
+	server := httptest.NewServer(app)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	e.Expect(err).ToBeNil()
+	defer res.Body.Close()
+	e.Expect(res.StatusCode).ToBe(404)
+	res, err = http.Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	e.Expect(err).ToBeNil()
+	defer res.Body.Close()
+	e.Expect(res.StatusCode).ToBe(200)
+}
+
+func TestRequestMatchers(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Post("/movies", func(ctx *micro.Context) {
+		ctx.WriteString("v2 json")
+	}).Headers("X-Api-Version", "2").Consumes("application/json")
+	app.Post("/movies", func(ctx *micro.Context) {
+		ctx.WriteString("v2 xml")
+	}).Headers("X-Api-Version", "2").Consumes("text/*", "application/xml")
+	app.Post("/movies", func(ctx *micro.Context) {
+		ctx.WriteString("v1")
+	}).Queries("format", "")
+	app.Get("/secure", func(ctx *micro.Context) {
+		ctx.WriteString("secure")
+	}).Schemes("https")
+	e.Expect(app.Boot()).ToBeNil()
+	for _, test := range []struct {
+		method, url, contentType, version, expected string
+	}{
+		{"POST", "/movies", "application/json; charset=utf-8", "2", "v2 json"},
+		{"POST", "/movies", "text/xml", "2", "v2 xml"},
+		{"POST", "/movies?format=csv", "application/json", "1", "v1"},
+		{"POST", "/movies", "application/json", "1", "404 page not found\n"},
+		{"GET", "https://example.com/secure", "", "", "secure"},
+		{"GET", "http://example.com/secure", "", "", "404 page not found\n"},
+	} {
+		res := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method, test.url, nil)
+		e.Expect(err).ToBeNil()
+		req.Header.Set("Content-Type", test.contentType)
+		req.Header.Set("X-Api-Version", test.version)
+		app.ServeHTTP(res, req)
+		e.Expect(res.Body.String()).ToBe(test.expected)
+	}
+	e.Expect(func() { micro.NewHeaderMatcher("X-Api-Version") }).ToPanic()
+}
+
 /**********************************/
 /*      EVENT EMITTER TESTS       */
 /**********************************/
@@ -382,6 +667,184 @@ func TestEventEmitter(t *testing.T) {
 	e.Expect(em.HasListener("event")).ToBeTrue()
 }
 
+func TestEventEmitterOn(t *testing.T) {
+	var calls []string
+	e := expect.New(t)
+	em := micro.NewEventEmitter()
+	listener := func(name string) func(string, ...interface{}) bool {
+		return func(event string, arguments ...interface{}) bool {
+			calls = append(calls, name+":"+event)
+			return name != "stop"
+		}
+	}
+	off := em.On("request.start", listener("on"))
+	em.On("request.*", listener("namespace"))
+	offSegment := em.On("*.start", listener("segment"))
+	em.On("*", listener("all"))
+	em.OnWithPriority("request.start", 10, listener("priority"))
+	em.Once("request.start", listener("once"))
+	em.Emit("request.start")
+	e.Expect(calls).ToEqual([]string{"priority:request.start", "on:request.start", "namespace:request.start", "segment:request.start", "all:request.start", "once:request.start"})
+	calls = nil
+	off()
+	offSegment()
+	em.Emit("request.start")
+	e.Expect(calls).ToEqual([]string{"priority:request.start", "namespace:request.start", "all:request.start"})
+	calls = nil
+	em.Emit("response.end")
+	e.Expect(calls).ToEqual([]string{"all:response.end"})
+	calls = nil
+	em.OnWithPriority("request.end", 20, listener("stop"))
+	em.Emit("request.end")
+	e.Expect(calls).ToEqual([]string{"stop:request.end"})
+	e.Expect(em.HasListener("request.foo")).ToBeTrue()
+	em.RemoveAllListeners("*")
+	e.Expect(em.HasListener("response.end")).ToBeFalse()
+}
+
+func TestEventEmitterAsync(t *testing.T) {
+	var (
+		called int32
+		wg     sync.WaitGroup
+	)
+	e := expect.New(t)
+	em := micro.NewEventEmitter()
+	em.SetWorkers(2)
+	em.On("event", func(event string, arguments ...interface{}) bool {
+		atomic.AddInt32(&called, int32(arguments[0].(int)))
+		return true
+	})
+	done := []<-chan struct{}{}
+	for i := 0; i < 10; i++ {
+		done = append(done, em.EmitAsync("event", 1))
+	}
+	for _, d := range done {
+		<-d
+	}
+	e.Expect(atomic.LoadInt32(&called)).ToBe(int32(10))
+	// run with -race
+	called = 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			off := em.On("event", func(event string, arguments ...interface{}) bool { return true })
+			em.Once("event", func(event string, arguments ...interface{}) bool { return true })
+			em.Emit("event", 1)
+			<-em.EmitAsync("event", 1)
+			em.HasListener("event")
+			off()
+		}()
+	}
+	wg.Wait()
+	e.Expect(atomic.LoadInt32(&called)).ToBe(int32(20))
+}
+
+func TestEventEmitterClose(t *testing.T) {
+	var called int32
+	e := expect.New(t)
+	em := micro.NewEventEmitter()
+	em.SetWorkers(1)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	em.On("panic", func(event string, arguments ...interface{}) bool { panic("listener") })
+	em.On("event", func(event string, arguments ...interface{}) bool {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&called, 1)
+		return true
+	})
+	// a panicking listener neither crashes the process nor blocks the caller
+	<-em.EmitAsync("panic")
+	for i := 0; i < 5; i++ {
+		em.EmitAsync("event")
+	}
+	em.Close()
+	e.Expect(atomic.LoadInt32(&called)).ToBe(int32(5))
+	<-em.EmitAsync("event")
+	e.Expect(atomic.LoadInt32(&called)).ToBe(int32(6))
+	em.Close()
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	var events []string
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", func(next micro.Next) { next() })
+	app.Get("/unauthorized", func(rw http.ResponseWriter, next micro.Next) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		next()
+	}).SetName("unauthorized")
+	app.Get("/panic", func() { panic("panic") })
+	app.On("*", func(event string, arguments ...interface{}) bool {
+		switch payload := arguments[0].(type) {
+		case micro.RouteMatchedEvent:
+			event = event + " " + payload.Route.Path()
+		case micro.HandlerBeforeEvent:
+			event = event + " " + payload.Route.Path()
+		case micro.HandlerAfterEvent:
+			event = event + " " + payload.Route.Path()
+		case micro.StatusErrorEvent:
+			event = fmt.Sprint(event, " ", payload.Status)
+		case micro.PanicEvent:
+			event = fmt.Sprint(event, " ", payload.Error)
+		case micro.RequestEndEvent:
+			event = fmt.Sprint(event, " ", payload.Status, " ", payload.Length, " ", payload.Route.Name())
+			e.Expect(payload.Duration > 0).ToBeTrue()
+		}
+		events = append(events, event)
+		return true
+	})
+	app.ServeHTTP(httptest.NewRecorder(), micro.MustWithResult(http.NewRequest("GET", "/unauthorized", nil)).(*http.Request))
+	e.Expect(events).ToEqual([]string{
+		"request.start",
+		"route.matched /",
+		"handler.before /",
+		"route.matched /unauthorized",
+		"handler.before /unauthorized",
+		"response.status_error 401",
+		"handler.after /unauthorized",
+		"handler.after /",
+		"request.end 401 13 unauthorized",
+	})
+	events = nil
+	app.ServeHTTP(httptest.NewRecorder(), micro.MustWithResult(http.NewRequest("GET", "/panic", nil)).(*http.Request))
+	e.Expect(events[len(events)-2:]).ToEqual([]string{"panic panic", "request.end 500 21 _panic__GET_HEAD_"})
+}
+
+func TestTypedEvents(t *testing.T) {
+	type MovieCreated struct {
+		Title string
+	}
+	var titles []string
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/movies", func() {})
+	unsubscribe := micro.Subscribe(app.EventEmitter, func(event MovieCreated) error {
+		titles = append(titles, event.Title)
+		return nil
+	})
+	micro.Subscribe(app.EventEmitter, func(event MovieCreated) error {
+		return fmt.Errorf("cannot index %s", event.Title)
+	})
+	micro.Subscribe(app.EventEmitter, func(event micro.RequestEndEvent) error {
+		titles = append(titles, event.Request.URL.Path)
+		return nil
+	})
+	// route events have their own payload types
+	micro.Subscribe(app.EventEmitter, func(event micro.HandlerBeforeEvent) error {
+		titles = append(titles, "before "+event.Route.Path())
+		return nil
+	})
+	e.Expect(micro.EventName[MovieCreated]()).ToBe("micro_test.MovieCreated")
+	err := micro.Publish(app.EventEmitter, MovieCreated{Title: "Alien"})
+	e.Expect(err.Error()).ToBe("cannot index Alien")
+	app.ServeHTTP(httptest.NewRecorder(), micro.MustWithResult(http.NewRequest("GET", "/movies", nil)).(*http.Request))
+	e.Expect(titles).ToEqual([]string{"Alien", "before /movies", "/movies"})
+	unsubscribe()
+	micro.Publish(app.EventEmitter, MovieCreated{Title: "Aliens"})
+	e.Expect(len(titles)).ToBe(3)
+}
+
 /**********************************/
 /*     ROUTE COLLECTION TESTS     */
 /**********************************/
@@ -452,6 +915,1190 @@ func TestContextReadJson(t *testing.T) {
 	e.Expect(response.Body.String()).ToEqual("foobar")
 }
 
+/**********************************/
+/*        MIDDLEWARE TESTS        */
+/**********************************/
+
+func TestAccessLogger(t *testing.T) {
+	e := expect.New(t)
+	output := new(bytes.Buffer)
+	logger := micro.NewAccessLogger(output, micro.CombinedLogFormat)
+	logger.TrustedProxies = micro.MustWithResult(micro.NewTrustedProxies("10.0.0.0/8", "192.168.1.1")).(micro.TrustedProxies)
+	app := micro.New()
+	app.Use("/", logger.Handler)
+	app.Get("/greet/:name", func(ctx *micro.Context) {
+		ctx.WriteString("Hello ", ctx.RequestVars["name"])
+	}).SetName("greet")
+	app.Get("/panic", func() { panic("panic") })
+	request := httptest.NewRequest("GET", "/greet/bob", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "203.0.113.7, 192.168.1.1")
+	request.Header.Set("X-Request-ID", "abc")
+	request.Header.Set("User-Agent", "test")
+	app.ServeHTTP(httptest.NewRecorder(), request)
+	e.Expect(output.String()).ToContain(`203.0.113.7 - - [`)
+	e.Expect(output.String()).ToContain(`] "GET /greet/bob HTTP/1.1" 200 9 "" "test" "greet" `)
+	// the request ID header is logged only once validated by RequestIDMiddleware
+	e.Expect(output.String()).Not().ToContain(`"abc"`)
+	e.Expect(strings.HasSuffix(output.String(), ` ""`+"\n")).ToBeTrue()
+	output.Reset()
+	logger.Format = micro.JSONLogFormat
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	entry := map[string]interface{}{}
+	e.Expect(json.Unmarshal(output.Bytes(), &entry)).ToBeNil()
+	e.Expect(entry["status"]).ToEqual(float64(500))
+	e.Expect(entry["remote_addr"]).ToEqual("192.0.2.1")
+	e.Expect(entry["route"]).ToEqual("_panic__GET_HEAD_")
+	output.Reset()
+	// the route of requests without endpoint is not the passthrough route of the logger
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	entry = map[string]interface{}{}
+	e.Expect(json.Unmarshal(output.Bytes(), &entry)).ToBeNil()
+	e.Expect(entry["status"]).ToEqual(float64(404))
+	e.Expect(entry["route"]).ToEqual("")
+	output.Reset()
+	logger.SampleRate = 1e-9
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/greet/bob", nil))
+	e.Expect(output.Len()).ToBe(0)
+	// the zero value logs every request
+	app = micro.New()
+	app.Use("/", (&micro.AccessLogger{Output: output}).Handler)
+	app.Get("/", func() {})
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	e.Expect(output.String()).ToContain(`"GET / HTTP/1.1" 200 -`)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	e := expect.New(t)
+	output := new(bytes.Buffer)
+	app := micro.New()
+	app.Use("/", micro.NewRequestIDMiddleware().Handler)
+	app.Use("/", micro.NewAccessLogger(output, micro.CommonLogFormat).Handler)
+	app.DeclareRequestService(micro.RequestID(""))
+	app.Get("/", func(ctx *micro.Context, id micro.RequestID, r *http.Request) {
+		e.Expect(string(id)).ToBe(ctx.RequestID())
+		e.Expect(micro.RequestIDFromContext(r.Context())).ToBe(ctx.RequestID())
+	})
+	e.Expect(app.Boot()).ToBeNil()
+	res := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	app.ServeHTTP(res, request)
+	e.Expect(res.Header().Get("X-Request-ID")).ToBe("abc-123")
+	e.Expect(output.String()).ToContain(`"abc-123"`)
+	res = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("X-Request-ID", "abc\n123")
+	app.ServeHTTP(res, request)
+	e.Expect(len(res.Header().Get("X-Request-ID"))).ToBe(32)
+}
+
+func TestHTTPClient(t *testing.T) {
+	e := expect.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-Request-ID"), r.Header.Get("Traceparent"))
+	}))
+	defer upstream.Close()
+	app := micro.New()
+	e.Expect(app.HTTPClient().Timeout).ToBe(30 * time.Second)
+	app.Use("/", micro.NewRequestIDMiddleware().Handler)
+	app.Get("/", func(ctx *micro.Context, client *http.Client) {
+		request, _ := http.NewRequestWithContext(ctx.Request.Context(), "GET", upstream.URL, nil)
+		response, err := client.Do(request)
+		e.Expect(err).ToBeNil()
+		defer response.Body.Close()
+		io.Copy(ctx.Response, response.Body)
+	})
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	res := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	request.Header.Set("Traceparent", traceparent)
+	app.ServeHTTP(res, request)
+	e.Expect(res.Body.String()).ToBe("abc-123 " + traceparent)
+	res = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	request.Header.Set("Traceparent", "invalid")
+	app.ServeHTTP(res, request)
+	e.Expect(res.Body.String()).ToBe("abc-123 ")
+}
+
+func TestMetrics(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	metrics := app.Metrics("/metrics")
+	app.Use("/", func(next micro.Next) { next() }).SetName("middleware")
+	metrics.Register(micro.CollectorFunc(func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, "movies_total 3")
+		return err
+	}))
+	app.Get("/movies/:id", func(ctx *micro.Context) {
+		ctx.WriteString("movie")
+	}).SetName("movie")
+	server := httptest.NewServer(app)
+	defer server.Close()
+	for _, path := range []string{"/movies/1", "/movies/2", "/notfound"} {
+		res, err := http.Get(server.URL + path)
+		e.Expect(err).ToBeNil()
+		res.Body.Close()
+	}
+	res, err := http.Get(server.URL + "/metrics")
+	e.Expect(err).ToBeNil()
+	defer res.Body.Close()
+	body := string(micro.MustWithResult(ioutil.ReadAll(res.Body)).([]byte))
+	e.Expect(body).ToContain("micro_http_requests_in_flight 1\n")
+	e.Expect(body).ToContain(`micro_http_requests_total{route="movie",method="GET",code="200"} 2`)
+	e.Expect(body).ToContain(`micro_http_requests_total{route="none",method="GET",code="404"} 1`)
+	e.Expect(body).ToContain(`micro_http_request_duration_seconds_bucket{route="movie",method="GET",le="+Inf"} 2`)
+	e.Expect(body).ToContain(`micro_http_request_duration_seconds_count{route="movie",method="GET"} 2`)
+	e.Expect(body).ToContain(`micro_http_response_size_bytes_sum{route="movie",method="GET"} 10`)
+	e.Expect(body).ToContain("movies_total 3\n")
+	// a stalled scrape does not block requests
+	reader, writer := io.Pipe()
+	go metrics.Collect(writer)
+	res, err = http.Get(server.URL + "/movies/3")
+	e.Expect(err).ToBeNil()
+	res.Body.Close()
+	e.Expect(res.StatusCode).ToBe(200)
+	reader.Close()
+}
+
+func TestSessionManager(t *testing.T) {
+	e := expect.New(t)
+	cookieStore, err := micro.NewCookieSessionStore([]byte("0123456789abcdef0123456789abcdef"))
+	e.Expect(err).ToBeNil()
+	fileStore, err := micro.NewFileSessionStore(t.TempDir())
+	e.Expect(err).ToBeNil()
+	for _, store := range []micro.SessionStore{cookieStore, micro.NewMemorySessionStore(), fileStore} {
+		manager := micro.NewSessionManager(store)
+		manager.Cookie.Secure = false
+		app := micro.New()
+		app.Use("/", manager.Handler)
+		app.DeclareRequestService((*micro.Session)(nil))
+		app.Post("/login", func(session *micro.Session) {
+			session.Regenerate()
+			session.Set("user", "bob")
+			session.AddFlash("Welcome")
+		})
+		app.Get("/", func(session *micro.Session, ctx *micro.Context) {
+			ctx.WriteString(session.Get("user"), " ", session.Flashes())
+		})
+		app.Post("/logout", func(session *micro.Session) {
+			session.Destroy()
+		})
+		e.Expect(app.Boot()).ToBeNil()
+		server := httptest.NewServer(app)
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		get := func(method, path string) string {
+			req, _ := http.NewRequest(method, server.URL+path, nil)
+			res, err := client.Do(req)
+			e.Expect(err).ToBeNil()
+			defer res.Body.Close()
+			return string(micro.MustWithResult(ioutil.ReadAll(res.Body)).([]byte))
+		}
+		e.Expect(get("GET", "/")).ToBe("<nil> []")
+		e.Expect(len(jar.Cookies(micro.MustWithResult(url.Parse(server.URL)).(*url.URL)))).ToBe(0)
+		get("POST", "/login")
+		e.Expect(get("GET", "/")).ToBe("bob [Welcome]")
+		e.Expect(get("GET", "/")).ToBe("bob []")
+		get("POST", "/logout")
+		e.Expect(get("GET", "/")).ToBe("<nil> []")
+		server.Close()
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	e := expect.New(t)
+	store := micro.NewMemorySessionStore()
+	manager := micro.NewSessionManager(store)
+	manager.IdleTimeout = time.Millisecond
+	session, err := manager.Load(httptest.NewRequest("GET", "/", nil))
+	e.Expect(err).ToBeNil()
+	e.Expect(session.IsNew()).ToBeTrue()
+	session.Set("user", "bob")
+	res := httptest.NewRecorder()
+	e.Expect(manager.Save(res, session)).ToBeNil()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(res.Result().Cookies()[0])
+	session, _ = manager.Load(request)
+	e.Expect(session.Get("user")).ToBe("bob")
+	time.Sleep(2 * time.Millisecond)
+	session, _ = manager.Load(request)
+	e.Expect(session.IsNew()).ToBeTrue()
+	e.Expect(session.Get("user")).ToBeNil()
+}
+
+func TestCSRFProtection(t *testing.T) {
+	e := expect.New(t)
+	for _, mode := range []micro.CSRFMode{micro.CSRFDoubleSubmit, micro.CSRFSynchronizer} {
+		protection := micro.NewCSRFProtection()
+		protection.Mode = mode
+		protection.Cookie.Secure = false
+		sessions := micro.NewSessionManager(micro.NewMemorySessionStore())
+		sessions.Cookie.Secure = false
+		app := micro.New()
+		app.Use("/", sessions.Handler)
+		app.Use("/", protection.Handler)
+		app.Get("/form", func(ctx *micro.Context) {
+			ctx.WriteString(ctx.CSRFToken())
+		})
+		app.Post("/form", func(ctx *micro.Context) {
+			ctx.WriteString("posted")
+		})
+		app.Post("/webhook", func(ctx *micro.Context) {
+			ctx.WriteString("webhook")
+		}).SetAttribute(micro.CSRFExempt, true)
+		app.Error(403, func(ctx *micro.Context) {
+			ctx.WriteString("invalid token")
+		})
+		server := httptest.NewServer(app)
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		do := func(method, path string, body io.Reader, header string) (int, string) {
+			req, _ := http.NewRequest(method, server.URL+path, body)
+			req.Header.Set("Content-Type", formContentType)
+			req.Header.Set("X-CSRF-Token", header)
+			res, err := client.Do(req)
+			e.Expect(err).ToBeNil()
+			defer res.Body.Close()
+			return res.StatusCode, string(micro.MustWithResult(ioutil.ReadAll(res.Body)).([]byte))
+		}
+		_, token := do("GET", "/form", nil, "")
+		_, otherToken := do("GET", "/form", nil, "")
+		e.Expect(token).Not().ToBe(otherToken)
+		code, body := do("POST", "/form", nil, "")
+		e.Expect(code).ToBe(403)
+		e.Expect(body).ToBe("invalid token")
+		code, body = do("POST", "/form", nil, token)
+		e.Expect(code).ToBe(200)
+		e.Expect(body).ToBe("posted")
+		code, _ = do("POST", "/form", strings.NewReader("csrf_token="+url.QueryEscape(otherToken)), "")
+		e.Expect(code).ToBe(200)
+		code, _ = do("POST", "/webhook", nil, "")
+		e.Expect(code).ToBe(200)
+		server.Close()
+	}
+}
+
+func TestCSRFProtectionUploads(t *testing.T) {
+	e := expect.New(t)
+	protection := micro.NewCSRFProtection()
+	protection.Cookie.Secure = false
+	app := micro.New()
+	app.Use("/", protection.Handler)
+	app.Get("/form", func(ctx *micro.Context) {
+		ctx.WriteString(ctx.CSRFToken())
+	})
+	app.Post("/upload", func(ctx *micro.Context) {
+		file, err := ctx.SaveFile("document", filepath.Join(t.TempDir(), "document.txt"))
+		e.Expect(err).ToBeNil()
+		ctx.WriteString(file.Size, " ", ctx.Request.PostForm.Get("title"))
+	}).SetAttribute(micro.UploadOptionsAttribute, micro.UploadOptions{MaxFileSize: 16})
+	app.Error(403, func(ctx *micro.Context) {
+		ctx.WriteString("invalid token")
+	})
+	app.Error(413, func(ctx *micro.Context) {
+		ctx.WriteString("too large")
+	})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/form", nil))
+	token, cookies := res.Body.String(), res.Result().Cookies()
+	upload := func(token string, content string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		writer.WriteField("title", "report")
+		part, _ := writer.CreateFormFile("document", "document.txt")
+		part.Write([]byte(content))
+		writer.WriteField("csrf_token", token)
+		writer.Close()
+		request := httptest.NewRequest("POST", "/upload", body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		app.ServeHTTP(res, request)
+		return res
+	}
+	res = upload(token, "hello")
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Body.String()).ToBe("5 report")
+	res = upload("invalid", "hello")
+	e.Expect(res.Code).ToBe(403)
+	e.Expect(res.Body.String()).ToBe("invalid token")
+	res = upload(token, strings.Repeat("a", 32))
+	e.Expect(res.Code).ToBe(413)
+	e.Expect(res.Body.String()).ToBe("too large")
+}
+
+func TestBasicAuth(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/admin", micro.NewBasicAuth("admin", map[string]string{"bob": "secret"}).Handler)
+	app.DeclareRequestService((*micro.Principal)(nil))
+	app.Get("/admin", func(ctx *micro.Context, principal *micro.Principal) {
+		ctx.WriteString("Hello ", principal.Subject)
+	})
+	app.Error(401, func(ctx *micro.Context) {
+		ctx.WriteString("Unauthorized")
+	})
+	e.Expect(app.Boot()).ToBeNil()
+	for _, test := range []struct {
+		username, password string
+		code               int
+		body               string
+	}{
+		{"bob", "secret", 200, "Hello bob"},
+		{"bob", "wrong", 401, "Unauthorized"},
+		{"alice", "secret", 401, "Unauthorized"},
+	} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.SetBasicAuth(test.username, test.password)
+		app.ServeHTTP(res, req)
+		e.Expect(res.Code).ToBe(test.code)
+		e.Expect(res.Body.String()).ToBe(test.body)
+		if test.code == 401 {
+			e.Expect(res.Header().Get("WWW-Authenticate")).ToBe(`Basic realm="admin", charset="UTF-8"`)
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", micro.NewBearerAuth("api", func(token string) (*micro.Principal, error) {
+		if token != "opaque" {
+			return nil, fmt.Errorf("unknown token")
+		}
+		return &micro.Principal{Subject: "bob"}, nil
+	}).Handler)
+	app.Get("/", func(ctx *micro.Context, principal *micro.Principal) {
+		ctx.WriteString(principal.Subject)
+	})
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer opaque")
+	app.ServeHTTP(res, req)
+	e.Expect(res.Body.String()).ToBe("bob")
+	res = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer other")
+	app.ServeHTTP(res, req)
+	e.Expect(res.Code).ToBe(401)
+	e.Expect(res.Header().Get("WWW-Authenticate")).ToBe(`Bearer realm="api", error="invalid_token", error_description="unknown token"`)
+}
+
+func TestJWTAuth(t *testing.T) {
+	e := expect.New(t)
+	secret := []byte("secret")
+	rsaKey := micro.MustWithResult(rsa.GenerateKey(rand.Reader, 2048)).(*rsa.PrivateKey)
+	ecKey := micro.MustWithResult(ecdsa.GenerateKey(elliptic.P256(), rand.Reader)).(*ecdsa.PrivateKey)
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","n":%q,"e":"AQAB"},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":%q,"y":%q},
+		{"kty":"oct","kid":"hmac","k":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(secret))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	e.Expect(os.WriteFile(path, []byte(jwks), 0600)).ToBeNil()
+	keys, err := micro.LoadJWKS(path)
+	e.Expect(err).ToBeNil()
+	e.Expect(len(keys)).ToBe(3)
+	auth := micro.NewJWTAuth("api", keys)
+	auth.Audience = "movies"
+	claims := map[string]interface{}{
+		"sub":   "bob",
+		"aud":   []string{"movies"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"admin"},
+		"scope": "read write",
+	}
+	for _, token := range []string{
+		signJWT("HS256", "hmac", claims, secret),
+		signJWT("RS256", "rsa", claims, rsaKey),
+		signJWT("ES256", "ec", claims, ecKey),
+	} {
+		principal, err := auth.Verify(token)
+		e.Expect(err).ToBeNil()
+		e.Expect(principal.Subject).ToBe("bob")
+		e.Expect(principal.HasRole("admin")).ToBeTrue()
+		e.Expect(principal.Scopes).ToEqual([]string{"read", "write"})
+	}
+	// a public key must not be usable as a HMAC secret
+	_, err = auth.Verify(signJWT("HS256", "rsa", claims, rsaKey.N.Bytes()))
+	e.Expect(err).Not().ToBeNil()
+	_, err = auth.Verify(signJWT("none", "hmac", claims, nil))
+	e.Expect(err).Not().ToBeNil()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = auth.Verify(signJWT("HS256", "hmac", claims, secret))
+	e.Expect(err.Error()).ToBe("token is expired")
+	claims["exp"], claims["aud"] = time.Now().Add(time.Hour).Unix(), "series"
+	_, err = auth.Verify(signJWT("HS256", "hmac", claims, secret))
+	e.Expect(err.Error()).ToBe("invalid audience")
+}
+
+func TestAuthorizer(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Use("/", micro.NewBearerAuth("api", func(token string) (*micro.Principal, error) {
+		roles, scopes, _ := strings.Cut(token, ":")
+		return &micro.Principal{Subject: token, Roles: strings.Fields(roles), Scopes: strings.Fields(scopes)}, nil
+	}).Handler)
+	app.Use("/", micro.NewAuthorizer().Handler)
+	admin := micro.NewControllerCollection().SetAttribute(micro.RolesAttribute, []string{"admin"})
+	admin.Get("/users", func(ctx *micro.Context, route *micro.Route) {
+		ctx.WriteString(route.Name())
+	}).SetName("users").SetAttribute(micro.ScopesAttribute, "write")
+	admin.Get("/own/:owner", func(ctx *micro.Context) {
+		ctx.WriteString("own")
+	}).SetAttribute(micro.RolesAttribute, nil).SetAttribute(micro.PolicyAttribute, micro.Policy(func(principal *micro.Principal, ctx *micro.Context) bool {
+		return ctx.Request.URL.Path == "/admin/own/"+principal.Subject
+	}))
+	app.Mount("/admin", admin)
+	app.Get("/public", func(ctx *micro.Context) {
+		ctx.WriteString("public")
+	})
+	app.Get("/literal", func(ctx *micro.Context) {}).SetAttribute(micro.PolicyAttribute, func(principal *micro.Principal, ctx *micro.Context) bool {
+		return principal.Subject == "bob"
+	})
+	// attributes of unexpected types deny access
+	app.Get("/invalid/policy", func(ctx *micro.Context) {}).SetName("invalid_policy").
+		SetAttribute(micro.PolicyAttribute, func(ctx *micro.Context) bool { return true })
+	app.Get("/invalid/roles", func(ctx *micro.Context) {}).SetName("invalid_roles").
+		SetAttribute(micro.RolesAttribute, map[string]bool{"admin": true})
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	err := app.Boot()
+	e.Expect(err).Not().ToBeNil()
+	e.Expect(err.Error()).ToBe("route invalid_policy : attribute policy is a func(*micro.Context) bool, expected a micro.Policy\n" +
+		"route invalid_roles : attribute roles is a map[string]bool, expected a string or a []string")
+	for _, test := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/admin/users", "admin:write", 200},
+		{"/admin/users", "admin:read", 403},
+		{"/admin/users", "user:write", 403},
+		{"/admin/own/bob", "bob", 200},
+		{"/admin/own/bob", "alice", 403},
+		{"/public", "user", 200},
+		{"/literal", "bob", 200},
+		{"/literal", "alice", 403},
+		{"/invalid/policy", "bob", 500},
+		{"/invalid/roles", "admin", 500},
+	} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		app.ServeHTTP(res, req)
+		e.Expect(res.Code).ToBe(test.code)
+		if test.path == "/admin/users" && test.code == 200 {
+			e.Expect(res.Body.String()).ToBe("users")
+		}
+	}
+	// requests without principal are challenged only if the authorizer has a challenge
+	authorizer := micro.NewAuthorizer()
+	app = micro.New()
+	app.Use("/", authorizer.Handler)
+	app.Get("/admin", func() {}).SetAttribute(micro.RolesAttribute, "admin")
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/admin", nil))
+	e.Expect(res.Code).ToBe(403)
+	e.Expect(res.Header().Get("WWW-Authenticate")).ToBe("")
+	authorizer.Challenge = `Bearer realm="api"`
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/admin", nil))
+	e.Expect(res.Code).ToBe(401)
+	e.Expect(res.Header().Get("WWW-Authenticate")).ToBe(`Bearer realm="api"`)
+}
+
+func TestRateLimiter(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	limiter := micro.NewRateLimiter(2, time.Minute)
+	limiter.Key = micro.RateLimitByHeader("X-API-Key")
+	app.Use("/", limiter.Handler)
+	app.Get("/", func(ctx *micro.Context) {
+		ctx.WriteString("ok")
+	})
+	app.Get("/login", func(ctx *micro.Context) {
+		ctx.WriteString("ok")
+	}).SetAttribute(micro.RateLimitAttribute, micro.RateLimit{Limit: 1, Window: time.Hour, Algorithm: micro.SlidingWindow})
+	app.Error(429, func(ctx *micro.Context) {
+		ctx.WriteString("Too many requests")
+	})
+	request := func(path, key string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		app.ServeHTTP(res, req)
+		return res
+	}
+	res := request("/", "a")
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Header().Get("RateLimit-Limit")).ToBe("2")
+	e.Expect(res.Header().Get("RateLimit-Remaining")).ToBe("1")
+	e.Expect(res.Header().Get("RateLimit-Policy")).ToBe("2;w=60")
+	e.Expect(request("/", "a").Code).ToBe(200)
+	res = request("/", "a")
+	e.Expect(res.Code).ToBe(429)
+	e.Expect(res.Body.String()).ToBe("Too many requests")
+	e.Expect(res.Header().Get("Retry-After")).ToBe("30")
+	// other keys and routes with their own limit have their own quota
+	e.Expect(request("/", "b").Code).ToBe(200)
+	e.Expect(request("/", "").Code).ToBe(200)
+	e.Expect(request("/login", "a").Code).ToBe(200)
+	res = request("/login", "a")
+	e.Expect(res.Code).ToBe(429)
+	e.Expect(res.Header().Get("RateLimit-Policy")).ToBe("1;w=3600")
+}
+
+func TestMemoryLimiterStore(t *testing.T) {
+	e := expect.New(t)
+	store := micro.NewMemoryLimiterStore()
+	for _, algorithm := range []micro.RateLimitAlgorithm{micro.TokenBucket, micro.SlidingWindow} {
+		limit := micro.RateLimit{Limit: 3, Window: 100 * time.Millisecond, Algorithm: algorithm}
+		key := fmt.Sprint("key", algorithm)
+		for i := 0; i < 3; i++ {
+			result, err := store.Take(key, limit)
+			e.Expect(err).ToBeNil()
+			e.Expect(result.Allowed).ToBeTrue()
+		}
+		result, _ := store.Take(key, limit)
+		e.Expect(result.Allowed).ToBeFalse()
+		e.Expect(result.RetryAfter > 0 && result.RetryAfter <= limit.Window).ToBeTrue()
+		time.Sleep(2*limit.Window + 10*time.Millisecond)
+		result, _ = store.Take(key, limit)
+		e.Expect(result.Allowed).ToBeTrue()
+	}
+	var wg sync.WaitGroup
+	var allowed int32
+	limit := micro.RateLimit{Limit: 50, Window: time.Hour}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, _ := store.Take("concurrent", limit); result.Allowed {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	e.Expect(allowed).ToBe(int32(50))
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.MaxBodyBytes = 16
+	handler := func(ctx *micro.Context) {
+		var body map[string]string
+		if err := ctx.ReadJSON(&body); err != nil {
+			ctx.Next()
+			return
+		}
+		ctx.WriteString(body["name"])
+	}
+	app.Post("/", handler)
+	app.Post("/large", handler).SetAttribute(micro.MaxBodyBytesAttribute, int64(64))
+	app.Error(413, func(ctx *micro.Context) {
+		ctx.WriteString("Too large")
+	})
+	for _, test := range []struct {
+		path, body    string
+		contentLength bool
+		code          int
+		response      string
+	}{
+		{"/", `{"name":"bob"}`, true, 200, "bob"},
+		{"/", `{"name":"bob the builder"}`, true, 413, "Too large"},
+		{"/", `{"name":"bob the builder"}`, false, 413, "Too large"},
+		{"/large", `{"name":"bob the builder"}`, false, 200, "bob the builder"},
+	} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		if !test.contentLength {
+			req.ContentLength = -1
+		}
+		app.ServeHTTP(res, req)
+		e.Expect(res.Code).ToBe(test.code)
+		e.Expect(res.Body.String()).ToBe(test.response)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/slow", func(ctx *micro.Context) {
+		select {
+		case <-ctx.Request.Context().Done():
+		case <-time.After(time.Second):
+			ctx.WriteString("done")
+		}
+	}).SetAttribute(micro.TimeoutAttribute, 10*time.Millisecond).SetAttribute(micro.ReadTimeoutAttribute, time.Second)
+	app.Get("/fast", func(ctx *micro.Context) {
+		ctx.WriteString("done")
+	}).SetAttribute(micro.TimeoutAttribute, time.Second)
+	// the timeout response is written at the deadline even if the handler ignores the request context,
+	// a handler flushing its response completes it
+	written := make(chan error, 1)
+	app.Get("/blocking", func(ctx *micro.Context) {
+		ctx.Response.Header().Set("X-Handler", "blocking")
+		time.Sleep(200 * time.Millisecond)
+		_, err := ctx.WriteString("late")
+		written <- err
+	}).SetAttribute(micro.TimeoutAttribute, 10*time.Millisecond)
+	app.Get("/stream", func(ctx *micro.Context) {
+		ctx.WriteString("first ")
+		http.NewResponseController(ctx.Response).Flush()
+		time.Sleep(50 * time.Millisecond)
+		ctx.WriteString("second")
+	}).SetAttribute(micro.TimeoutAttribute, 10*time.Millisecond)
+	app.Get("/partial", func(ctx *micro.Context) {
+		ctx.Response.Header().Set("Content-Type", "application/json")
+		ctx.WriteString(`{"movies":[`)
+		panic("database error")
+	}).SetAttribute(micro.TimeoutAttribute, time.Second)
+	app.Error(503, func(ctx *micro.Context) {
+		ctx.WriteString("Timeout")
+	})
+	app.Error(500, func(ctx *micro.Context) {
+		ctx.WriteString("Internal Server Error")
+	})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/slow", nil))
+	e.Expect(res.Code).ToBe(503)
+	e.Expect(res.Body.String()).ToBe("Timeout")
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/fast", nil))
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Body.String()).ToBe("done")
+
+	ends := make(chan micro.RequestEndEvent, 1)
+	app.On(micro.EventRequestEnd, func(event string, arguments ...interface{}) bool {
+		if end := arguments[0].(micro.RequestEndEvent); end.Request.URL.Path == "/blocking" {
+			ends <- end
+		}
+		return true
+	})
+	res = httptest.NewRecorder()
+	start := time.Now()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/blocking", nil))
+	e.Expect(time.Since(start) < 150*time.Millisecond).ToBeTrue()
+	e.Expect(res.Code).ToBe(503)
+	e.Expect(res.Body.String()).ToBe("Timeout")
+	e.Expect(res.Header().Get("X-Handler")).ToBe("")
+	e.Expect(<-written).ToBe(http.ErrHandlerTimeout)
+	e.Expect((<-ends).Status).ToBe(503)
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/stream", nil))
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Body.String()).ToBe("first second")
+	// the partial response of a handler panicking before the timeout is replaced by the error response
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/partial", nil))
+	e.Expect(res.Code).ToBe(500)
+	e.Expect(res.Body.String()).ToBe("Internal Server Error")
+	e.Expect(res.Header().Get("Content-Type")).Not().ToBe("application/json")
+}
+
+func TestUploads(t *testing.T) {
+	e := expect.New(t)
+	directory, destination := t.TempDir(), t.TempDir()
+	app := micro.New()
+	var temporaryPaths []string
+	app.Post("/upload", func(ctx *micro.Context) {
+		files, err := ctx.Files("photos")
+		if err != nil {
+			ctx.Next()
+			return
+		}
+		for _, file := range files {
+			temporaryPaths = append(temporaryPaths, file.Path)
+		}
+		saved, err := ctx.SaveFile("document", filepath.Join(destination, "document.txt"))
+		e.Expect(err).ToBeNil()
+		ctx.WriteString(len(files), " ", files[0].ContentType, " ", saved.Size, " ", saved.SHA256, " ", ctx.Request.PostForm.Get("title"))
+	}).SetAttribute(micro.UploadOptionsAttribute, micro.UploadOptions{
+		MaxFileSize:  1024,
+		MaxFiles:     3,
+		AllowedTypes: []string{"image/*", "text/plain"},
+		Directory:    directory,
+	})
+	app.Error(413, func(ctx *micro.Context) {
+		ctx.WriteString("Too large")
+	})
+	app.Error(415, func(ctx *micro.Context) {
+		ctx.WriteString("Unsupported")
+	})
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 32))
+	upload := func(parts map[string][][]byte) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("title", "holidays")
+		for _, field := range []string{"photos", "document"} {
+			for i, content := range parts[field] {
+				part, _ := writer.CreateFormFile(field, fmt.Sprint(field, i))
+				part.Write(content)
+			}
+		}
+		writer.Close()
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		app.ServeHTTP(res, req)
+		return res
+	}
+	res := upload(map[string][][]byte{"photos": {png, png}, "document": {[]byte("hello")}})
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Body.String()).ToBe("2 image/png 5 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 holidays")
+	content, _ := os.ReadFile(filepath.Join(destination, "document.txt"))
+	e.Expect(string(content)).ToBe("hello")
+	e.Expect(len(temporaryPaths)).ToBe(2)
+	// temporary files are removed once the request ends
+	entries, _ := os.ReadDir(directory)
+	e.Expect(len(entries)).ToBe(0)
+
+	res = upload(map[string][][]byte{"photos": {png, append(png, make([]byte, 1024)...)}})
+	e.Expect(res.Code).ToBe(413)
+	e.Expect(res.Body.String()).ToBe("Too large")
+	res = upload(map[string][][]byte{"photos": {png, png, png, png}})
+	e.Expect(res.Code).ToBe(413)
+	res = upload(map[string][][]byte{"photos": {[]byte("%PDF-1.4")}})
+	e.Expect(res.Code).ToBe(415)
+	e.Expect(res.Body.String()).ToBe("Unsupported")
+	entries, _ = os.ReadDir(directory)
+	e.Expect(len(entries)).ToBe(0)
+}
+
+func TestURL(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	app.Get("/users/:id/posts/:slug?", func() {}).SetName("posts").Assert("id", `\d+`)
+	catalog := micro.NewControllerCollection()
+	catalog.Get("/:category/(\\d+)", func() {}).SetName("product")
+	app.Mount("/catalog/", catalog)
+	for _, test := range []struct {
+		name   string
+		pairs  []string
+		path   string
+		failed bool
+	}{
+		{"posts", []string{"id", "1", "slug", "hello"}, "/users/1/posts/hello", false},
+		{"posts", []string{"id", "1"}, "/users/1/posts", false},
+		{"posts", []string{"id", "bob"}, "", true},
+		{"posts", []string{"slug", "hello"}, "", true},
+		{"product", []string{"category", "books", "1", "42"}, "/catalog/books/42", false},
+		{"unknown", nil, "", true},
+	} {
+		path, err := app.URL(test.name, test.pairs...)
+		e.Expect(path).ToBe(test.path)
+		e.Expect(err != nil).ToBe(test.failed)
+	}
+}
+
+func TestRender(t *testing.T) {
+	e := expect.New(t)
+	templates := fstest.MapFS{
+		"layouts/main.html":     {Data: []byte(`<main>{{template "partials/flashes" .}}{{template "content" .}}</main>`)},
+		"partials/flashes.html": {Data: []byte(`{{range flashes}}<p>{{.}}</p>{{end}}`)},
+		"users/show.html":       {Data: []byte(`<a href="{{url "user" "id" .ID}}">{{.Name}}</a>{{csrfField}}`)},
+	}
+	app := micro.New()
+	renderer := micro.NewTemplateRenderer(templates)
+	renderer.Debug = true
+	app.Renderer = renderer
+	app.Use("/", micro.NewSessionManager(micro.NewMemorySessionStore()).Handler)
+	app.Get("/users/:id", func(ctx *micro.Context) {
+		ctx.Session().AddFlash("welcome")
+		micro.Must(ctx.Render(201, "users/show", map[string]interface{}{"ID": 1, "Name": "<bob>"}))
+	}).SetName("user")
+	app.Get("/missing", func(ctx *micro.Context) {
+		e.Expect(ctx.Render(200, "missing", nil)).Not().ToBeNil()
+	})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/users/1", nil))
+	e.Expect(res.Code).ToBe(201)
+	e.Expect(res.Header().Get("Content-Type")).ToBe("text/html; charset=utf-8")
+	e.Expect(res.Body.String()).ToBe(`<main><p>welcome</p><a href="/users/1">&lt;bob&gt;</a><input type="hidden" name="csrf_token" value=""></main>`)
+	// templates are reloaded in debug mode
+	templates["users/show.html"] = &fstest.MapFile{Data: []byte(`{{.Name}}`)}
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/users/1", nil))
+	e.Expect(res.Body.String()).ToBe(`<main><p>welcome</p>&lt;bob&gt;</main>`)
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/missing", nil))
+	e.Expect(res.Body.String()).ToBe("")
+}
+
+func TestRenderCSRFField(t *testing.T) {
+	e := expect.New(t)
+	protection := micro.NewCSRFProtection()
+	protection.Cookie.Secure = false
+	protection.Field = "authenticity_token"
+	app := micro.New()
+	app.Renderer = micro.NewTemplateRenderer(fstest.MapFS{
+		"form.html": {Data: []byte(`{{csrfField}}`)},
+	})
+	app.Use("/", protection.Handler)
+	app.Get("/form", func(ctx *micro.Context) {
+		micro.Must(ctx.Render(200, "form", nil))
+	})
+	app.Post("/form", func(ctx *micro.Context) {
+		ctx.WriteString("posted")
+	})
+	server := httptest.NewServer(app)
+	defer server.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	res, err := client.Get(server.URL + "/form")
+	e.Expect(err).ToBeNil()
+	body := string(micro.MustWithResult(ioutil.ReadAll(res.Body)).([]byte))
+	res.Body.Close()
+	e.Expect(body).ToContain(`<input type="hidden" name="authenticity_token" value="`)
+	_, token, _ := strings.Cut(body, `value="`)
+	token, _, _ = strings.Cut(token, `"`)
+	// the rendered field validates the form
+	res, err = client.PostForm(server.URL+"/form", url.Values{"authenticity_token": {token}})
+	e.Expect(err).ToBeNil()
+	res.Body.Close()
+	e.Expect(res.StatusCode).ToBe(200)
+}
+
+func TestHTTPCache(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	calls := 0
+	cache := micro.NewLRUCache(3)
+	app.Use("/", micro.NewRequestIDMiddleware().Handler)
+	app.Use("/", micro.NewHTTPCache(cache).Handler)
+	app.Get("/movies", func(ctx *micro.Context) {
+		calls++
+		ctx.Response.Header().Set("Vary", "Accept-Language")
+		ctx.WriteString("movies ", ctx.Request.Header.Get("Accept-Language"))
+	}).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute, CacheControl: "public, max-age=60"})
+	app.Get("/news", func(ctx *micro.Context) {
+		calls++
+		ctx.WriteString("news")
+	})
+	app.Get("/missing", func(ctx *micro.Context) {
+		ctx.Response.WriteHeader(404)
+	}).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute})
+	app.Get("/account", func(ctx *micro.Context) {
+		calls++
+		ctx.WriteString("account ", ctx.Request.Header.Get("Authorization"))
+	}).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute, CacheControl: "max-age=60"})
+	app.Get("/catalog", func(ctx *micro.Context) {
+		calls++
+		ctx.WriteString("catalog")
+	}).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute, CacheControl: "public, max-age=60"})
+	authorization := ""
+	request := func(path, language, etag string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		req.Header.Set("Accept-Language", language)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		app.ServeHTTP(res, req)
+		return res
+	}
+	res := request("/movies", "en", "")
+	etag := res.Header().Get("ETag")
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Body.String()).ToBe("movies en")
+	e.Expect(res.Header().Get("Cache-Control")).ToBe("public, max-age=60")
+	e.Expect(strings.HasPrefix(etag, `"`)).ToBeTrue()
+	res = request("/movies", "en", "")
+	e.Expect(res.Body.String()).ToBe("movies en")
+	e.Expect(res.Header().Get("ETag")).ToBe(etag)
+	e.Expect(res.Header().Get("Age")).ToBe("0")
+	// every response has its own request ID
+	e.Expect(len(res.Header().Values("X-Request-Id"))).ToBe(1)
+	e.Expect(calls).ToBe(1)
+	res = request("/movies", "en", etag)
+	e.Expect(res.Code).ToBe(304)
+	e.Expect(res.Body.String()).ToBe("")
+	res = request("/movies", "fr", "")
+	e.Expect(res.Body.String()).ToBe("movies fr")
+	e.Expect(calls).ToBe(2)
+	// responses are not stored without TTL but have an ETag
+	res = request("/news", "en", "")
+	res = request("/news", "en", res.Header().Get("ETag"))
+	e.Expect(res.Code).ToBe(304)
+	e.Expect(calls).ToBe(4)
+	res = request("/missing", "en", "")
+	e.Expect(res.Code).ToBe(404)
+	e.Expect(res.Header().Get("ETag")).ToBe("")
+	// the Vary headers of /movies are stored with its 2 variants
+	e.Expect(cache.Len()).ToBe(3)
+	// responses to authenticated requests are stored only if they are public
+	authorization = "Bearer alice"
+	e.Expect(request("/account", "en", "").Body.String()).ToBe("account Bearer alice")
+	authorization = "Bearer bob"
+	e.Expect(request("/account", "en", "").Body.String()).ToBe("account Bearer bob")
+	e.Expect(calls).ToBe(6)
+	request("/catalog", "en", "")
+	authorization = ""
+	e.Expect(request("/catalog", "en", "").Body.String()).ToBe("catalog")
+	e.Expect(calls).ToBe(7)
+	// the Vary headers count in the capacity of the cache and are evicted like responses
+	e.Expect(cache.Len()).ToBe(3)
+	e.Expect(request("/movies", "en", "").Body.String()).ToBe("movies en")
+	e.Expect(calls).ToBe(8)
+}
+
+func TestHTTPCacheSharing(t *testing.T) {
+	e := expect.New(t)
+	policy := micro.CachePolicy{TTL: time.Minute, CacheControl: "max-age=60"}
+	page := func(ctx *micro.Context) {
+		user := "anonymous"
+		if cookie, err := ctx.Request.Cookie("session"); err == nil {
+			user = cookie.Value
+		}
+		ctx.WriteString("hello ", user)
+	}
+	app := micro.New()
+	app.Use("/", micro.NewHTTPCache(micro.NewLRUCache(10)).Handler)
+	app.Get("/", page).SetAttribute(micro.CachePolicyAttribute, policy)
+	// responses to requests with a session cookie are not shared
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(&http.Cookie{Name: "session", Value: "alice"})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, request)
+	e.Expect(res.Body.String()).ToBe("hello alice")
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	e.Expect(res.Body.String()).ToBe("hello anonymous")
+	// cached responses do not bypass the middlewares registered after the cache
+	app = micro.New()
+	app.Use("/", micro.NewHTTPCache(micro.NewLRUCache(10)).Handler)
+	app.Use("/", micro.NewBearerAuth("api", func(token string) (*micro.Principal, error) {
+		return &micro.Principal{Subject: token}, nil
+	}).Handler)
+	app.Get("/", page).SetAttribute(micro.CachePolicyAttribute, micro.CachePolicy{TTL: time.Minute, CacheControl: "public"})
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer alice")
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, request)
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(res.Header().Get("ETag")).Not().ToBe("")
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	e.Expect(res.Code).ToBe(401)
+}
+
+func TestLRUCache(t *testing.T) {
+	e := expect.New(t)
+	cache := micro.NewLRUCache(2)
+	cache.Set("a", &micro.CachedResponse{Status: 200}, time.Minute)
+	cache.Set("b", &micro.CachedResponse{Status: 201}, time.Minute)
+	e.Expect(cache.Get("a").Status).ToBe(200)
+	cache.Set("c", &micro.CachedResponse{Status: 202}, time.Minute)
+	// b is the least recently used response
+	e.Expect(cache.Get("b")).ToBeNil()
+	e.Expect(cache.Get("a")).Not().ToBeNil()
+	cache.Set("d", &micro.CachedResponse{Status: 203}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	e.Expect(cache.Get("d")).ToBeNil()
+	e.Expect(cache.Len()).ToBe(1)
+}
+
+func TestBufferResponses(t *testing.T) {
+	e := expect.New(t)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	app := micro.New()
+	app.BufferResponses = true
+	app.BufferThreshold = 16
+	app.Get("/panic", func(ctx *micro.Context) {
+		ctx.Response.Header().Set("Content-Type", "application/json")
+		ctx.WriteString(`{"movies":[`)
+		panic("database error")
+	})
+	app.Get("/large", func(ctx *micro.Context) {
+		ctx.WriteString(strings.Repeat("a", 32))
+		panic("database error")
+	})
+	app.Get("/missing", func(ctx *micro.Context) {
+		ctx.Response.WriteHeader(404)
+		ctx.WriteString("partial")
+		ctx.Next()
+	})
+	app.Get("/stream", func(ctx *micro.Context) {
+		ctx.WriteString("a")
+		e.Expect(http.NewResponseController(ctx.Response).Flush()).ToBeNil()
+		ctx.WriteString("b")
+	})
+	app.Error(500, func(ctx *micro.Context) {
+		ctx.WriteString("Internal Server Error")
+	})
+	app.Error(404, func(ctx *micro.Context) {
+		ctx.WriteString("Not Found")
+	})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/panic", nil))
+	e.Expect(res.Code).ToBe(500)
+	e.Expect(res.Body.String()).ToBe("Internal Server Error")
+	e.Expect(res.Header().Get("Content-Type")).Not().ToBe("application/json")
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/missing", nil))
+	e.Expect(res.Code).ToBe(404)
+	e.Expect(res.Body.String()).ToBe("Not Found")
+	// responses larger than the threshold are written and cannot be replaced
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/large", nil))
+	e.Expect(res.Code).ToBe(200)
+	e.Expect(strings.HasPrefix(res.Body.String(), strings.Repeat("a", 32))).ToBeTrue()
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/stream", nil))
+	e.Expect(res.Flushed).ToBeTrue()
+	e.Expect(res.Body.String()).ToBe("ab")
+}
+
+/**********************************/
+/*          CONFIG TESTS          */
+/**********************************/
+
+func TestConfig(t *testing.T) {
+	e := expect.New(t)
+	directory := t.TempDir()
+	jsonFile := filepath.Join(directory, "config.json")
+	tomlFile := filepath.Join(directory, "config.toml")
+	envFile := filepath.Join(directory, ".env")
+	os.WriteFile(jsonFile, []byte(`{"server":{"host":"example.com","port":80},"origins":["a.com","b.com"],"level":"info"}`), 0644)
+	os.WriteFile(tomlFile, []byte("# overrides\n[server]\nport = 8000 # comment\ntimeout = \"1m\"\n"), 0644)
+	os.WriteFile(envFile, []byte("export MOVIES_DATABASE_URL=\"postgres://localhost/movies\"\nMOVIES_LEVEL=debug\n"), 0644)
+	t.Setenv("MOVIES_LEVEL", "error")
+	app := micro.New()
+	config := app.Config(jsonFile, tomlFile, envFile, filepath.Join(directory, "missing.json"))
+	config.EnvPrefix = "MOVIES_"
+	config.Args = []string{"--debug", "--server.port=9000", "positional"}
+	e.Expect(config.Load()).ToBeNil()
+	settings := Settings{Ignored: "kept"}
+	e.Expect(config.Decode(&settings)).ToBeNil()
+	e.Expect(settings).ToEqual(Settings{
+		Server:   ServerSettings{Host: "example.com", Port: 9000, Timeout: time.Minute},
+		Database: "postgres://localhost/movies",
+		Origins:  []string{"a.com", "b.com"},
+		Level:    "error",
+		Debug:    true,
+		Ignored:  "kept",
+	})
+	app.Get("/", func(ctx *micro.Context, config *micro.Config) {
+		ctx.WriteString(config.String("server.host"))
+	})
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	e.Expect(res.Body.String()).ToBe("example.com")
+
+	config = micro.NewConfig()
+	config.SetDefault("server.port", 0)
+	config.SetDefault("level", "trace")
+	err := config.Decode(&Settings{})
+	e.Expect(err).Not().ToBeNil()
+	e.Expect(err.Error()).ToBe("config server.port : must be at least 1\nconfig database_url : is required\nconfig level : must be one of debug info error")
+	config.SetDefault("server.timeout", "soon")
+	e.Expect(config.Decode(&Settings{})).Not().ToBeNil()
+
+	os.WriteFile(tomlFile, []byte("[server\n"), 0644)
+	e.Expect(micro.NewConfig(tomlFile).Load()).Not().ToBeNil()
+}
+
+func TestConfigWatch(t *testing.T) {
+	e := expect.New(t)
+	file := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(file, []byte("name = \"movies\"\nport = 80\n"), 0644)
+	app := micro.New()
+	config := app.Config(file)
+	e.Expect(config.Load()).ToBeNil()
+	changes := make(chan micro.ConfigChangedEvent, 1)
+	app.On(micro.EventConfigChanged, func(event string, arguments ...interface{}) bool {
+		changes <- arguments[0].(micro.ConfigChangedEvent)
+		return true
+	})
+	stop := config.Watch(10 * time.Millisecond)
+	defer stop()
+	os.WriteFile(file, []byte("name = \"films\"\nport = 80\nhost = \"localhost\"\n"), 0644)
+	select {
+	case change := <-changes:
+		e.Expect(change.Keys).ToEqual([]string{"host", "name"})
+		e.Expect(change.Config.String("name")).ToBe("films")
+	case <-time.After(2 * time.Second):
+		t.Fatal("config.changed was not emitted")
+	}
+}
+
+/**********************************/
+/*          HEALTH TESTS          */
+/**********************************/
+
+func TestHealth(t *testing.T) {
+	e := expect.New(t)
+	app := micro.New()
+	database := errors.New("connection refused")
+	app.Health("/healthz").Register("database", micro.Readiness, time.Second, func(ctx context.Context) error {
+		return database
+	})
+	app.Readiness("/readyz").Register("slow", micro.Readiness, 10*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	app.Liveness("/livez").Register("goroutines", micro.Liveness, 0, func(ctx context.Context) error {
+		return nil
+	})
+	report := func(path string) (int, micro.HealthReport) {
+		res := httptest.NewRecorder()
+		app.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		report := micro.HealthReport{}
+		e.Expect(json.Unmarshal(res.Body.Bytes(), &report)).ToBeNil()
+		return res.Code, report
+	}
+	code, health := report("/healthz")
+	e.Expect(code).ToBe(http.StatusServiceUnavailable)
+	e.Expect(health.Status).ToBe(micro.HealthFail)
+	e.Expect(health.Checks["database"].Error).ToBe("connection refused")
+	e.Expect(health.Checks["slow"].Error).ToBe("timed out after 10ms")
+	e.Expect(health.Checks["goroutines"].Status).ToBe(micro.HealthPass)
+	code, health = report("/livez")
+	e.Expect(code).ToBe(http.StatusOK)
+	e.Expect(len(health.Checks)).ToBe(1)
+
+	database = nil
+	app.HealthChecks().Register("slow", micro.Readiness, 0, func(ctx context.Context) error { return nil })
+	code, _ = report("/readyz")
+	e.Expect(code).ToBe(http.StatusOK)
+
+	server := httptest.NewUnstartedServer(app)
+	server.Start()
+	defer server.Close()
+	app.ShutdownDelay = 50 * time.Millisecond
+	done := make(chan error)
+	go func() { done <- app.Shutdown(context.Background(), server.Config) }()
+	time.Sleep(10 * time.Millisecond)
+	res, err := http.Get(server.URL + "/readyz")
+	e.Expect(err).ToBeNil()
+	health = micro.HealthReport{}
+	json.NewDecoder(res.Body).Decode(&health)
+	res.Body.Close()
+	e.Expect(res.StatusCode).ToBe(http.StatusServiceUnavailable)
+	e.Expect(health.Checks["draining"].Status).ToBe(micro.HealthFail)
+	code, _ = report("/livez")
+	e.Expect(code).ToBe(http.StatusOK)
+	e.Expect(<-done).ToBeNil()
+}
+
 /**********************************/
 /*           UTILS TESTS          */
 /**********************************/
@@ -467,6 +2114,28 @@ func TestMustWithResult(t *testing.T) {
 /*            FIXTURES          */
 /********************************/
 
+// signJWT returns a token signed with key
+func signJWT(alg string, kid string, claims map[string]interface{}, key interface{}) string {
+	header := micro.MustWithResult(json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})).([]byte)
+	payload := micro.MustWithResult(json.Marshal(claims)).([]byte)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature = micro.MustWithResult(rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])).([]byte)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		micro.Must(err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
 type Foo struct {
 	Bar string
 }
@@ -504,3 +2173,18 @@ func (p Person) Find(id int) *Person {
 var (
 	PersonRepository Person
 )
+
+type ServerSettings struct {
+	Host    string        `config:"host" default:"localhost"`
+	Port    int           `config:"port" default:"8080" validate:"min=1,max=65535"`
+	Timeout time.Duration `config:"timeout" default:"30s"`
+}
+
+type Settings struct {
+	Server   ServerSettings `config:"server"`
+	Database string         `config:"database_url" validate:"required"`
+	Origins  []string       `config:"origins"`
+	Level    string         `config:"level" validate:"oneof=debug info error"`
+	Debug    bool
+	Ignored  string `config:"-"`
+}
@@ -0,0 +1,68 @@
+package microtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**********************************/
+/*            JSON PATH           */
+/**********************************/
+
+// JSONPath returns the value at path in a document decoded by json.Unmarshal.
+// Paths start with $ followed by .field, ["field"] or [index] selectors.
+func JSONPath(document interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSON path %s must start with $", path)
+	}
+	value, rest := document, path[1:]
+	for rest != "" {
+		var (
+			key   string
+			index = -1
+		)
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key, rest = rest[1:end+1], rest[end+1:]
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end == -1 {
+				return nil, fmt.Errorf("JSON path %s : unterminated selector", path)
+			}
+			key, rest = rest[2:end], rest[end+2:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSON path %s : unterminated selector", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("JSON path %s : invalid index %s", path, rest[1:end])
+			}
+			index, rest = i, rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSON path %s : unexpected %s", path, rest)
+		}
+		if index >= 0 {
+			array, ok := value.([]interface{})
+			if !ok || index >= len(array) {
+				return nil, fmt.Errorf("JSON path %s : index %d not found", path, index)
+			}
+			value = array[index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("JSON path %s : field %s not found", path, key)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("JSON path %s : field %s not found", path, key)
+		}
+	}
+	return value, nil
+}
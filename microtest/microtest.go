@@ -0,0 +1,383 @@
+// Package microtest helps testing micro applications.
+//
+// Requests are built with a fluent client and handled by the application
+// without a network connection, expectations fail the test with a message
+// describing the response.
+//
+// Example:
+//
+//    client := microtest.New(app)
+//    client.Get("/greet/bob").WithHeader("Accept", "application/json").
+//        Expect(t).Status(200).JSONPath("$.name", "bob").Route("greet")
+package microtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/interactiv/micro"
+)
+
+/**********************************/
+/*             CLIENT             */
+/**********************************/
+
+// Client sends requests to an application
+type Client struct {
+	App *micro.Micro
+	// Header is sent with every request
+	Header http.Header
+	// Jar stores the cookies of responses and sends them with requests if not nil
+	Jar http.CookieJar
+}
+
+// New returns a new Client
+func New(app *micro.Micro) *Client {
+	return &Client{App: app, Header: http.Header{}}
+}
+
+// Override replaces the services of the application injector that have the type
+// of service, or that implement Type if Type is a pointer to an interface,
+// until the end of the test. Overriding services is safe in parallel tests, but the
+// requests of every test sharing the application resolve the replacement.
+//
+// Example:
+//
+//    client.Override(t, &FakeMailer{}, (*Mailer)(nil))
+func (client *Client) Override(t testing.TB, service interface{}, Type interface{}) {
+	t.Cleanup(client.App.Injector().Replace(service, Type))
+}
+
+// Capture records the events of the application matching pattern until the end of the test
+func (client *Client) Capture(t testing.TB, pattern string) *EventRecorder {
+	recorder := &EventRecorder{}
+	t.Cleanup(client.App.On(pattern, recorder.record))
+	return recorder
+}
+
+// Get returns a GET request
+func (client *Client) Get(path string) *Request { return client.Request("GET", path) }
+
+// Head returns a HEAD request
+func (client *Client) Head(path string) *Request { return client.Request("HEAD", path) }
+
+// Post returns a POST request
+func (client *Client) Post(path string) *Request { return client.Request("POST", path) }
+
+// Put returns a PUT request
+func (client *Client) Put(path string) *Request { return client.Request("PUT", path) }
+
+// Patch returns a PATCH request
+func (client *Client) Patch(path string) *Request { return client.Request("PATCH", path) }
+
+// Delete returns a DELETE request
+func (client *Client) Delete(path string) *Request { return client.Request("DELETE", path) }
+
+// Request returns a request with a method
+func (client *Client) Request(method string, path string) *Request {
+	request := httptest.NewRequest(method, path, nil)
+	for name, values := range client.Header {
+		request.Header[name] = append([]string(nil), values...)
+	}
+	if client.Jar != nil {
+		for _, cookie := range client.Jar.Cookies(request.URL) {
+			request.AddCookie(cookie)
+		}
+	}
+	return &Request{client: client, Request: request}
+}
+
+/**********************************/
+/*             REQUEST            */
+/**********************************/
+
+// Request is a request being built
+type Request struct {
+	*http.Request
+	client *Client
+	err    error
+}
+
+// WithHeader sets a header
+func (request *Request) WithHeader(name string, value string) *Request {
+	request.Header.Set(name, value)
+	return request
+}
+
+// WithCookie adds a cookie
+func (request *Request) WithCookie(cookie *http.Cookie) *Request {
+	request.AddCookie(cookie)
+	return request
+}
+
+// WithBody sets the body and its content type
+func (request *Request) WithBody(contentType string, body io.Reader) *Request {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		request.err = err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(content))
+	request.ContentLength = int64(len(content))
+	request.Header.Set("Content-Type", contentType)
+	return request
+}
+
+// WithJSON sets a JSON body
+func (request *Request) WithJSON(v interface{}) *Request {
+	content, err := json.Marshal(v)
+	if err != nil {
+		request.err = err
+	}
+	return request.WithBody("application/json", bytes.NewReader(content))
+}
+
+// WithForm sets a URL encoded form body
+func (request *Request) WithForm(values url.Values) *Request {
+	return request.WithBody("application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+// Do sends the request to the application
+func (request *Request) Do() (*Response, error) {
+	if request.err != nil {
+		return nil, request.err
+	}
+	response := &Response{}
+	recorder := httptest.NewRecorder()
+	// events are recorded while the request is handled, concurrent requests
+	// of the same application would be recorded as well
+	unsubscribe := request.client.App.On("*", func(event string, arguments ...interface{}) bool {
+		response.Events.record(event, arguments...)
+		if len(arguments) > 0 {
+			if start, ok := arguments[0].(micro.RequestStartEvent); ok && start.Request == request.Request {
+				response.Context = start.Context
+			}
+		}
+		return true
+	})
+	request.client.App.ServeHTTP(recorder, request.Request)
+	unsubscribe()
+	response.Response = recorder.Result()
+	response.Response.Request = request.Request
+	response.Body = recorder.Body.Bytes()
+	if request.client.Jar != nil {
+		request.client.Jar.SetCookies(request.URL, response.Cookies())
+	}
+	return response, nil
+}
+
+// Expect sends the request to the application and returns expectations on the response
+func (request *Request) Expect(t testing.TB) *Expectation {
+	t.Helper()
+	response, err := request.Do()
+	if err != nil {
+		t.Fatalf("%s %s : %s", request.Method, request.URL, err)
+	}
+	return &Expectation{t: t, Response: response}
+}
+
+/**********************************/
+/*            RESPONSE            */
+/**********************************/
+
+// Response is the response of the application
+type Response struct {
+	*http.Response
+	Body []byte
+	// Context is the context of the request
+	Context *micro.Context
+	// Events are the events emitted while the request was handled
+	Events EventRecorder
+}
+
+// Route returns the name of the endpoint that handled the request,
+// or an empty string if no route matched
+func (response *Response) Route() string {
+	if response.Context == nil {
+		return ""
+	}
+	if endpoint := response.Context.Endpoint(); endpoint != nil {
+		return endpoint.Name()
+	}
+	if route := response.Context.Route(); route != nil {
+		return route.Name()
+	}
+	return ""
+}
+
+// Expectation checks a response
+type Expectation struct {
+	t        testing.TB
+	Response *Response
+}
+
+func (expectation *Expectation) fail(format string, arguments ...interface{}) {
+	expectation.t.Helper()
+	response := expectation.Response
+	expectation.t.Errorf("%s %s : %s\nresponse %d : %s", response.Request.Method, response.Request.URL,
+		fmt.Sprintf(format, arguments...), response.StatusCode, truncate(string(response.Body), 512))
+}
+
+// Status expects the status code
+func (expectation *Expectation) Status(code int) *Expectation {
+	expectation.t.Helper()
+	if expectation.Response.StatusCode != code {
+		expectation.fail("expected status %d, got %d", code, expectation.Response.StatusCode)
+	}
+	return expectation
+}
+
+// Header expects the value of a header
+func (expectation *Expectation) Header(name string, value string) *Expectation {
+	expectation.t.Helper()
+	if actual := expectation.Response.Header.Get(name); actual != value {
+		expectation.fail("expected header %s to be %q, got %q", name, value, actual)
+	}
+	return expectation
+}
+
+// Body expects the body
+func (expectation *Expectation) Body(body string) *Expectation {
+	expectation.t.Helper()
+	if actual := string(expectation.Response.Body); actual != body {
+		expectation.fail("expected body %q, got %q", body, actual)
+	}
+	return expectation
+}
+
+// BodyContains expects the body to contain a string
+func (expectation *Expectation) BodyContains(s string) *Expectation {
+	expectation.t.Helper()
+	if !strings.Contains(string(expectation.Response.Body), s) {
+		expectation.fail("expected body to contain %q", s)
+	}
+	return expectation
+}
+
+// JSON expects the body to be the JSON encoding of v
+func (expectation *Expectation) JSON(v interface{}) *Expectation {
+	expectation.t.Helper()
+	var actual interface{}
+	if err := json.Unmarshal(expectation.Response.Body, &actual); err != nil {
+		expectation.fail("invalid JSON body : %s", err)
+		return expectation
+	}
+	if expected := normalizeJSON(v); !reflect.DeepEqual(actual, expected) {
+		expectation.fail("expected JSON body %v, got %v", expected, actual)
+	}
+	return expectation
+}
+
+// JSONPath expects the value at path in the JSON body to be the JSON encoding of v.
+// Paths start with $ followed by .field, ["field"] or [index] selectors, for instance $.movies[0].title .
+func (expectation *Expectation) JSONPath(path string, v interface{}) *Expectation {
+	expectation.t.Helper()
+	var document interface{}
+	if err := json.Unmarshal(expectation.Response.Body, &document); err != nil {
+		expectation.fail("invalid JSON body : %s", err)
+		return expectation
+	}
+	actual, err := JSONPath(document, path)
+	if err != nil {
+		expectation.fail("%s", err)
+		return expectation
+	}
+	if expected := normalizeJSON(v); !reflect.DeepEqual(actual, expected) {
+		expectation.fail("expected %s to be %v, got %v", path, expected, actual)
+	}
+	return expectation
+}
+
+// Route expects the name of the route that handled the request
+func (expectation *Expectation) Route(name string) *Expectation {
+	expectation.t.Helper()
+	if actual := expectation.Response.Route(); actual != name {
+		expectation.fail("expected route %q, got %q", name, actual)
+	}
+	return expectation
+}
+
+// Emitted expects an event to have been emitted while the request was handled
+func (expectation *Expectation) Emitted(event string) *Expectation {
+	expectation.t.Helper()
+	if expectation.Response.Events.Count(event) == 0 {
+		expectation.fail("expected event %s to be emitted, got %v", event, expectation.Response.Events.Names())
+	}
+	return expectation
+}
+
+// normalizeJSON converts v to the values produced by json.Unmarshal
+func normalizeJSON(v interface{}) interface{} {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var normalized interface{}
+	json.Unmarshal(content, &normalized)
+	return normalized
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length] + "..."
+	}
+	return s
+}
+
+/**********************************/
+/*             EVENTS             */
+/**********************************/
+
+// Event is an event emitted by the application
+type Event struct {
+	Name      string
+	Arguments []interface{}
+}
+
+// EventRecorder records events, it is safe for concurrent use
+type EventRecorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (recorder *EventRecorder) record(event string, arguments ...interface{}) bool {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.events = append(recorder.events, Event{Name: event, Arguments: arguments})
+	return true
+}
+
+// Events returns the recorded events
+func (recorder *EventRecorder) Events() []Event {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]Event(nil), recorder.events...)
+}
+
+// Names returns the names of the recorded events
+func (recorder *EventRecorder) Names() []string {
+	names := []string{}
+	for _, event := range recorder.Events() {
+		names = append(names, event.Name)
+	}
+	return names
+}
+
+// Count returns the number of recorded events named name
+func (recorder *EventRecorder) Count(name string) int {
+	count := 0
+	for _, event := range recorder.Events() {
+		if event.Name == name {
+			count++
+		}
+	}
+	return count
+}
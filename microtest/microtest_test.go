@@ -0,0 +1,194 @@
+package microtest_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/interactiv/expect"
+	"github.com/interactiv/micro"
+	"github.com/interactiv/micro/microtest"
+)
+
+type Greeter interface {
+	Greet(name string) string
+}
+
+type englishGreeter struct{}
+
+func (englishGreeter) Greet(name string) string { return "Hello " + name }
+
+type fakeGreeter struct{}
+
+func (fakeGreeter) Greet(name string) string { return "Fake " + name }
+
+// failures records the failures of expectations
+type failures struct {
+	testing.TB
+	messages []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, arguments ...interface{}) {
+	f.messages = append(f.messages, fmt.Sprintf(format, arguments...))
+}
+
+func newApp() *micro.Micro {
+	app := micro.New()
+	app.Injector().Register(englishGreeter{})
+	app.Get("/greet/:name", func(ctx *micro.Context, greeter Greeter) {
+		ctx.WriteJSON(map[string]interface{}{
+			"name":     ctx.RequestVars["name"],
+			"greeting": greeter.Greet(ctx.RequestVars["name"]),
+			"tags":     []string{"a", "b"},
+		})
+	}).SetName("greet")
+	return app
+}
+
+func TestClient(t *testing.T) {
+	client := microtest.New(newApp())
+	client.Get("/greet/bob").WithHeader("Accept", "application/json").Expect(t).
+		Status(200).
+		Header("Content-Type", "application/json").
+		JSONPath("$.name", "bob").
+		JSONPath(`$["greeting"]`, "Hello bob").
+		JSONPath("$.tags[1]", "b").
+		Route("greet").
+		Emitted(micro.EventRouteMatched)
+	client.Get("/missing").Expect(t).Status(404).Route("")
+}
+
+func TestOverride(t *testing.T) {
+	client := microtest.New(newApp())
+	t.Run("fake", func(t *testing.T) {
+		client.Override(t, fakeGreeter{}, (*Greeter)(nil))
+		client.Get("/greet/bob").Expect(t).JSONPath("$.greeting", "Fake bob")
+	})
+	// services are restored at the end of the test
+	client.Get("/greet/bob").Expect(t).JSONPath("$.greeting", "Hello bob")
+}
+
+func TestOverrideParallel(t *testing.T) {
+	client := microtest.New(newApp())
+	// the first request boots the application
+	client.Get("/greet/bob").Expect(t).Status(200)
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprint("override ", i), func(t *testing.T) {
+			t.Parallel()
+			for j := 0; j < 10; j++ {
+				client.Transport(t, http.DefaultTransport)
+				client.Get("/greet/bob").Expect(t).JSONPath("$.greeting", "Hello bob")
+			}
+		})
+	}
+}
+
+func TestCapture(t *testing.T) {
+	e := expect.New(t)
+	client := microtest.New(newApp())
+	recorder := client.Capture(t, "request.*")
+	client.Get("/greet/bob").Expect(t).Status(200)
+	e.Expect(recorder.Names()).ToEqual([]string{micro.EventRequestStart, micro.EventRequestEnd})
+	e.Expect(recorder.Events()[1].Arguments[0].(micro.RequestEndEvent).Status).ToBe(200)
+}
+
+func TestCookies(t *testing.T) {
+	app := micro.New()
+	app.Use("/", micro.NewSessionManager(micro.NewMemorySessionStore()).Handler)
+	app.Get("/visits", func(ctx *micro.Context) {
+		visits, _ := ctx.Session().Get("visits").(int)
+		ctx.Session().Set("visits", visits+1)
+		ctx.WriteString(visits + 1)
+	})
+	client := microtest.New(app)
+	client.Jar, _ = cookiejar.New(nil)
+	client.Get("https://example.com/visits").Expect(t).Body("1")
+	client.Get("https://example.com/visits").Expect(t).Body("2")
+}
+
+func TestExpectationFailures(t *testing.T) {
+	e := expect.New(t)
+	client := microtest.New(newApp())
+	f := &failures{TB: t}
+	client.Get("/greet/bob").Expect(f).
+		Status(201).
+		JSONPath("$.name", "alice").
+		JSONPath("$.tags[5]", "a").
+		Route("other").
+		Emitted("other")
+	e.Expect(len(f.messages)).ToBe(5)
+	e.Expect(f.messages[0][:40]).ToBe("GET /greet/bob : expected status 201, go")
+}
+
+func TestJSONPath(t *testing.T) {
+	e := expect.New(t)
+	document := map[string]interface{}{
+		"movies": []interface{}{map[string]interface{}{"title": "Alien", "a.b": true}},
+	}
+	for _, test := range []struct {
+		path     string
+		expected interface{}
+		failed   bool
+	}{
+		{"$", document, false},
+		{"$.movies[0].title", "Alien", false},
+		{`$.movies[0]["a.b"]`, true, false},
+		{"$.movies[1]", nil, true},
+		{"$.movies.title", nil, true},
+		{"movies", nil, true},
+	} {
+		value, err := microtest.JSONPath(document, test.path)
+		e.Expect(value).ToEqual(test.expected)
+		e.Expect(err != nil).ToBe(test.failed)
+	}
+}
+
+func TestReplayTransport(t *testing.T) {
+	e := expect.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"title":"Alien","request":%q}`, r.Header.Get("X-Request-ID"))
+	}))
+	app := micro.New()
+	app.Use("/", micro.NewRequestIDMiddleware().Handler)
+	app.Get("/movie", func(ctx *micro.Context, client *http.Client) {
+		request, _ := http.NewRequestWithContext(ctx.Request.Context(), "GET", upstream.URL+"/movies/1", nil)
+		response, err := client.Do(request)
+		if err != nil {
+			ctx.Response.WriteHeader(http.StatusBadGateway)
+			ctx.WriteString(err.Error())
+			return
+		}
+		defer response.Body.Close()
+		ctx.Response.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+		io.Copy(ctx.Response, response.Body)
+	})
+	client := microtest.New(app)
+	client.Header.Set("X-Request-ID", "abc-123")
+	directory := t.TempDir()
+	transport := &microtest.ReplayTransport{Directory: directory, Mode: microtest.ReplayModeRecord}
+	t.Run("record", func(t *testing.T) {
+		client.Transport(t, transport)
+		client.Get("/movie").Expect(t).Status(200).JSONPath("$.request", "abc-123")
+	})
+	fixtures, _ := filepath.Glob(filepath.Join(directory, "get_127.0.0.1_*_movies_1_*.json"))
+	e.Expect(len(fixtures)).ToBe(1)
+	upstream.Close()
+	transport.Mode = microtest.ReplayModeReplay
+	client.Transport(t, transport)
+	client.Get("/movie").Expect(t).Status(200).
+		Header("Content-Type", "application/json").
+		JSONPath("$.title", "Alien")
+	os.Remove(fixtures[0])
+	client.Get("/movie").Expect(t).Status(http.StatusBadGateway).BodyContains("fixture not found")
+	_, err := transport.RoundTrip(httptest.NewRequest("GET", "http://example.com/", nil))
+	e.Expect(errors.Is(err, microtest.ErrFixtureNotFound)).ToBeTrue()
+}
@@ -0,0 +1,205 @@
+package microtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/interactiv/micro"
+)
+
+/**********************************/
+/*        REPLAY TRANSPORT        */
+/**********************************/
+
+// RecordEnv is the environment variable that switches replay transports
+// created by NewReplayTransport to ReplayModeRecord when not empty
+const RecordEnv = "MICROTEST_RECORD"
+
+// ReplayMode tells whether a ReplayTransport sends requests or replays fixtures
+type ReplayMode int
+
+const (
+	// ReplayModeReplay replays fixtures and fails requests without a fixture
+	ReplayModeReplay ReplayMode = iota
+	// ReplayModeRecord sends requests and stores their responses as fixtures
+	ReplayModeRecord
+)
+
+// ErrFixtureNotFound is returned in replay mode when a request has no fixture
+var ErrFixtureNotFound = fmt.Errorf("microtest: fixture not found, run the test with %s=1 to record it", RecordEnv)
+
+// Fixture is a recorded exchange, stored as a JSON file
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is a recorded request
+type FixtureRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// FixtureResponse is a recorded response. Body holds UTF-8 bodies,
+// Base64Body holds other bodies.
+type FixtureResponse struct {
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Base64Body []byte      `json:"base64Body,omitempty"`
+}
+
+// ReplayTransport is an http.RoundTripper replaying the responses stored in a directory.
+// Fixtures are named after the method, the host and the path of requests
+// and a hash of the method, the URL and the body, so a test can record several
+// requests to the same URL.
+//
+// Example:
+//
+//    client := microtest.New(app)
+//    client.Transport(t, microtest.NewReplayTransport("testdata/fixtures"))
+type ReplayTransport struct {
+	// Directory holds the fixtures
+	Directory string
+	Mode      ReplayMode
+	// Base sends requests in record mode, http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+// NewReplayTransport returns a ReplayTransport replaying the fixtures of directory,
+// or recording them if the MICROTEST_RECORD environment variable is set
+func NewReplayTransport(directory string) *ReplayTransport {
+	transport := &ReplayTransport{Directory: directory}
+	if os.Getenv(RecordEnv) != "" {
+		transport.Mode = ReplayModeRecord
+	}
+	return transport
+}
+
+// RoundTrip replays or records the response of the request
+func (transport *ReplayTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(request.Body); err != nil {
+			return nil, err
+		}
+		request.Body.Close()
+	}
+	path := filepath.Join(transport.Directory, FixtureName(request.Method, request.URL.String(), body))
+	if transport.Mode == ReplayModeRecord {
+		return transport.record(request, body, path)
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s %s : %w (%s)", request.Method, request.URL, ErrFixtureNotFound, path)
+	} else if err != nil {
+		return nil, err
+	}
+	fixture := &Fixture{}
+	if err = json.Unmarshal(content, fixture); err != nil {
+		return nil, fmt.Errorf("fixture %s : %w", path, err)
+	}
+	return fixture.Response.response(request), nil
+}
+
+func (transport *ReplayTransport) record(request *http.Request, body []byte, path string) (*http.Response, error) {
+	outgoing := request.Clone(request.Context())
+	outgoing.Body = io.NopCloser(bytes.NewReader(body))
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	response, err := base.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &Fixture{
+		Request:  FixtureRequest{Method: request.Method, URL: request.URL.String(), Body: string(body)},
+		Response: FixtureResponse{Status: response.StatusCode, Header: response.Header},
+	}
+	if utf8.Valid(responseBody) {
+		fixture.Response.Body = string(responseBody)
+	} else {
+		fixture.Response.Base64Body = responseBody
+	}
+	content, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return fixture.Response.response(request), nil
+}
+
+func (fixture FixtureResponse) response(request *http.Request) *http.Response {
+	body := []byte(fixture.Body)
+	if fixture.Base64Body != nil {
+		body = fixture.Base64Body
+	}
+	header := fixture.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+var unsafeFixtureCharacters = regexp.MustCompile(`[^\w\-.]+`)
+
+// FixtureName returns the name of the fixture file of a request
+func FixtureName(method string, url string, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, method+" "+url+"\n")
+	hash.Write(body)
+	name := url
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.Trim(unsafeFixtureCharacters.ReplaceAllString(name, "_"), "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return fmt.Sprintf("%s_%s_%s.json", strings.ToLower(method), name, hex.EncodeToString(hash.Sum(nil))[:12])
+}
+
+// Transport replaces the *http.Client of the application injector with a client
+// sending its requests through transport until the end of the test.
+// The client propagates request IDs like the default one. Services that kept
+// the previous client are not affected.
+func (client *Client) Transport(t testing.TB, transport http.RoundTripper) {
+	client.Override(t, micro.NewHTTPClient(transport), nil)
+}
@@ -0,0 +1,454 @@
+package micro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*             OPENAPI            */
+/**********************************/
+
+// Route attributes read when generating an OpenAPI document
+const (
+	// OpenAPISummary is a short summary of what the route does
+	OpenAPISummary = "openapi.summary"
+	// OpenAPIDescription is a long description of the route
+	OpenAPIDescription = "openapi.description"
+	// OpenAPITags is a []string used to group routes
+	OpenAPITags = "openapi.tags"
+	// OpenAPIRequest is a value whose type describes the request body
+	OpenAPIRequest = "openapi.request"
+	// OpenAPIResponse is a value whose type describes the response body
+	OpenAPIResponse = "openapi.response"
+	// OpenAPIIgnore excludes the route from the document when set to true
+	OpenAPIIgnore = "openapi.ignore"
+)
+
+// OpenAPIInfo is the metadata of an OpenAPI document
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIDocument returns an OpenAPI 3 document describing the routes of the application.
+// Passthrough routes are not described. Structs are described by components named
+// after their package and their name, such as models.Movie.
+// An error is returned when several routes have the same path and method, only the
+// first one is described, set the OpenAPIIgnore attribute on the others.
+// The application is booted if it is not, use Boot to get boot errors.
+func (e *Micro) OpenAPIDocument(info OpenAPIInfo) (map[string]interface{}, error) {
+	e.Boot()
+	var (
+		errs      []error
+		paths     = map[string]interface{}{}
+		routes    = map[string]*Route{}
+		generator = &schemaGenerator{schemas: map[string]interface{}{}, names: map[reflect.Type]string{}}
+	)
+	for _, route := range e.ControllerCollection.Routes {
+		if route.IsPassthrough() || route.Attribute(OpenAPIIgnore) == true {
+			continue
+		}
+		path := openAPIPath(route)
+		operations, ok := paths[path].(map[string]interface{})
+		if !ok {
+			operations = map[string]interface{}{}
+			paths[path] = operations
+		}
+		for _, method := range openAPIMethods(route) {
+			if previous, ok := routes[method+" "+path]; ok {
+				errs = append(errs, fmt.Errorf("route %s : operation %s %s already described by route %s",
+					route.Name(), strings.ToUpper(method), path, previous.Name()))
+				continue
+			}
+			routes[method+" "+path] = route
+			operations[method] = openAPIOperation(route, generator)
+		}
+	}
+	document := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(generator.schemas) > 0 {
+		document["components"] = map[string]interface{}{"schemas": generator.schemas}
+	}
+	return document, errors.Join(errs...)
+}
+
+// OpenAPI serves the OpenAPI document of the application as JSON at path
+// and as YAML at path + ".yaml". If viewer is true, a minimal HTML page
+// listing the operations is served at path + ".html". Errors of the document are logged
+// when it is first requested.
+//
+// Example:
+//
+//    app.OpenAPI("/openapi.json", micro.OpenAPIInfo{Title: "Movies", Version: "1.0"}, true)
+func (e *Micro) OpenAPI(path string, info OpenAPIInfo, viewer bool) {
+	var (
+		once     sync.Once
+		document map[string]interface{}
+	)
+	load := func() map[string]interface{} {
+		once.Do(func() {
+			var err error
+			if document, err = e.OpenAPIDocument(info); err != nil {
+				log.Println(err)
+			}
+		})
+		return document
+	}
+	e.Get(path, func(ctx *Context) {
+		ctx.WriteJSON(load())
+	}).SetAttribute(OpenAPIIgnore, true)
+	e.Get(path+".yaml", func(ctx *Context) {
+		ctx.Response.Header().Set("Content-Type", "application/yaml")
+		ctx.Response.Write(MustWithResult(OpenAPIYAML(load())).([]byte))
+	}).SetAttribute(OpenAPIIgnore, true)
+	if viewer {
+		e.Get(path+".html", func(ctx *Context) {
+			ctx.Response.Header().Set("Content-Type", "text/html; charset=utf-8")
+			Must(openAPIViewer.Execute(ctx.Response, newOpenAPIView(load())))
+		}).SetAttribute(OpenAPIIgnore, true)
+	}
+}
+
+// OpenAPIYAML converts an OpenAPI document to YAML
+func OpenAPIYAML(document map[string]interface{}) ([]byte, error) {
+	var value interface{}
+	// a JSON round trip turns the document into maps, slices and scalars only
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	buffer := new(bytes.Buffer)
+	writeYAML(buffer, value, 0)
+	return buffer.Bytes(), nil
+}
+
+// openAPIPath converts a route path to an OpenAPI path template :
+// /catalog/:category/(\d+) becomes /catalog/{category}/{1}
+func openAPIPath(route *Route) string {
+	i := 0
+	path := regexp.MustCompile(Pattern).ReplaceAllStringFunc(route.Path(), func(match string) string {
+		if i >= len(route.Params()) {
+			return match
+		}
+		i++
+		return "{" + route.Params()[i-1] + "}"
+	})
+	// remaining ? come from collection prefixes
+	path = strings.Replace(path, "?", "", -1)
+	path = regexp.MustCompile("/+").ReplaceAllString("/"+path, "/")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// openAPIMethods returns the lower case methods of a route, HEAD is omitted if GET is handled.
+func openAPIMethods(route *Route) []string {
+	if len(route.Methods()) == 0 {
+		return []string{"get", "put", "post", "delete", "patch"}
+	}
+	methods := []string{}
+	for _, method := range route.Methods() {
+		method = strings.ToLower(method)
+		if method == "head" && NewMethodMatcher(route.Methods()...).Match(&http.Request{Method: "GET"}) {
+			continue
+		}
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+func openAPIOperation(route *Route, generator *schemaGenerator) map[string]interface{} {
+	operation := map[string]interface{}{
+		"operationId": route.Name(),
+	}
+	if summary, ok := route.Attribute(OpenAPISummary).(string); ok {
+		operation["summary"] = summary
+	}
+	if description, ok := route.Attribute(OpenAPIDescription).(string); ok {
+		operation["description"] = description
+	}
+	if tags, ok := route.Attribute(OpenAPITags).([]string); ok {
+		operation["tags"] = tags
+	}
+	parameters := []interface{}{}
+	for _, param := range route.Params() {
+		schema := map[string]interface{}{"type": "string"}
+		if assertion := route.Assertion(param); assertion != "" {
+			schema["pattern"] = "^" + assertion + "$"
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":     param,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if request := route.Attribute(OpenAPIRequest); request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  openAPIContent(generator.schema(reflect.TypeOf(request))),
+		}
+	}
+	response := map[string]interface{}{"description": http.StatusText(http.StatusOK)}
+	if value := route.Attribute(OpenAPIResponse); value != nil {
+		response["content"] = openAPIContent(generator.schema(reflect.TypeOf(value)))
+	}
+	operation["responses"] = map[string]interface{}{"200": response}
+	return operation
+}
+
+func openAPIContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaGenerator reflects JSON schemas from go types,
+// named structs are stored as components and referenced.
+type schemaGenerator struct {
+	schemas map[string]interface{}
+	// names are the component names of the named structs
+	names map[reflect.Type]string
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			// set first so recursive types terminate
+			g.schemas[name] = map[string]interface{}{}
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// componentName returns the name of the package of t followed by the name of t,
+// the whole package path is used if another package has the same name
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:] + "." + t.Name()
+	if _, ok := g.schemas[name]; ok {
+		name = strings.NewReplacer("/", ".", "~", ".").Replace(t.PkgPath()) + "." + t.Name()
+	}
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	var (
+		properties = map[string]interface{}{}
+		required   = []string{}
+	)
+	g.fields(t, properties, &required, false)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fields adds the properties of the fields of t. Like encoding/json, the fields of
+// embedded structs without a JSON name are promoted, fields of the outer struct
+// take precedence over them. optional is true for the fields of embedded pointers.
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]interface{}, required *[]string, optional bool) {
+	embedded := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, tagged := field.Name, "", false
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name, tagged = parts[0], true
+			}
+			if len(parts) > 1 {
+				options = parts[1]
+			}
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && !tagged && fieldType.Kind() == reflect.Struct {
+			// encoding/json ignores embedded pointers to unexported structs
+			if field.PkgPath == "" || field.Type.Kind() != reflect.Ptr {
+				embedded = append(embedded, field)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if _, ok := properties[name]; ok {
+			continue
+		}
+		properties[name] = g.schema(field.Type)
+		if !optional && !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+	for _, field := range embedded {
+		if field.Type.Kind() == reflect.Ptr {
+			g.fields(field.Type.Elem(), properties, required, true)
+		} else {
+			g.fields(field.Type, properties, required, optional)
+		}
+	}
+}
+
+// writeYAML writes a value made of maps, slices and scalars as YAML block collections
+func writeYAML(buffer *bytes.Buffer, value interface{}, indent int) {
+	padding := strings.Repeat(" ", indent)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			buffer.WriteString(padding + strconv.Quote(key) + ":")
+			writeYAMLValue(buffer, v[key], indent)
+		}
+	case []interface{}:
+		for _, item := range v {
+			buffer.WriteString(padding + "-")
+			writeYAMLValue(buffer, item, indent)
+		}
+	}
+}
+
+func writeYAMLValue(buffer *bytes.Buffer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buffer.WriteString(" {}\n")
+			return
+		}
+		buffer.WriteString("\n")
+		writeYAML(buffer, v, indent+2)
+	case []interface{}:
+		if len(v) == 0 {
+			buffer.WriteString(" []\n")
+			return
+		}
+		buffer.WriteString("\n")
+		writeYAML(buffer, v, indent+2)
+	case string:
+		buffer.WriteString(" " + strconv.Quote(v) + "\n")
+	case float64:
+		buffer.WriteString(" " + strconv.FormatFloat(v, 'f', -1, 64) + "\n")
+	case bool:
+		buffer.WriteString(" " + strconv.FormatBool(v) + "\n")
+	default:
+		buffer.WriteString(" null\n")
+	}
+}
+
+// openAPIView is the data rendered by the HTML viewer
+type openAPIView struct {
+	Info       interface{}
+	Operations []openAPIViewOperation
+}
+
+type openAPIViewOperation struct {
+	Method, Path, Summary string
+	Parameters            []interface{}
+}
+
+func newOpenAPIView(document map[string]interface{}) openAPIView {
+	view := openAPIView{Info: document["info"]}
+	paths := document["paths"].(map[string]interface{})
+	pathNames := []string{}
+	for path := range paths {
+		pathNames = append(pathNames, path)
+	}
+	sort.Strings(pathNames)
+	for _, path := range pathNames {
+		operations := paths[path].(map[string]interface{})
+		methods := []string{}
+		for method := range operations {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			operation := operations[method].(map[string]interface{})
+			summary, _ := operation["summary"].(string)
+			parameters, _ := operation["parameters"].([]interface{})
+			view.Operations = append(view.Operations, openAPIViewOperation{
+				Method:     strings.ToUpper(method),
+				Path:       path,
+				Summary:    summary,
+				Parameters: parameters,
+			})
+		}
+	}
+	return view
+}
+
+var openAPIViewer = template.Must(template.New("openapi").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Info.Title}}</title></head>
+<body>
+<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+<p>{{.Info.Description}}</p>
+<table border="1" cellpadding="4">
+<tr><th>Method</th><th>Path</th><th>Summary</th><th>Parameters</th></tr>
+{{range .Operations}}<tr><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Summary}}</td><td>{{range .Parameters}}{{.name}} {{.schema.pattern}}<br>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
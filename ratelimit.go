@@ -0,0 +1,256 @@
+package micro
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*          RATE LIMITING         */
+/**********************************/
+
+// RateLimitAttribute is the route attribute holding the RateLimit of a route,
+// it replaces the default limit of the RateLimiter
+const RateLimitAttribute = "ratelimit"
+
+// RateLimitAlgorithm is the way requests are counted
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket refills Limit tokens per Window and allows bursts of Limit requests
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow weights the count of the previous window to smooth window boundaries
+	SlidingWindow
+)
+
+// RateLimit allows Limit requests per Window
+type RateLimit struct {
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+}
+
+// RateLimitResult is the state of a key after a request has been counted
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time left before the quota is fully available again
+	Reset time.Duration
+	// RetryAfter is the time left before a request is allowed, if the request is not allowed
+	RetryAfter time.Duration
+}
+
+// LimiterStore counts requests by key
+type LimiterStore interface {
+	// Take counts a request for key and returns whether it is allowed by limit
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKey returns the key requests are counted by
+type RateLimitKey func(ctx *Context, injector *Injector) string
+
+// RateLimiter is a middleware limiting the number of requests of a client.
+// The limit of the endpoint of the request is its RateLimitAttribute attribute
+// or the default limit of the RateLimiter, each route with a RateLimitAttribute
+// attribute has its own quota. Requests over the limit get the 429 status
+// handled by Micro.Error and a Retry-After header, all limited requests get
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers.
+//
+// Example:
+//
+//    limiter := micro.NewRateLimiter(100, time.Minute)
+//    limiter.Key = micro.RateLimitByPrincipal
+//    app.Use("/", limiter.Handler)
+//    app.Post("/login", login).SetAttribute(micro.RateLimitAttribute, micro.RateLimit{Limit: 5, Window: time.Minute})
+type RateLimiter struct {
+	Store LimiterStore
+	// Limit is the default limit, a zero Limit does not limit routes without a RateLimitAttribute attribute
+	Limit RateLimit
+	// Key returns the key requests are counted by, requests are counted by client IP if it returns an empty string
+	Key RateLimitKey
+	// TrustedProxies are the proxies whose X-Forwarded-For header is used to find the client address
+	TrustedProxies TrustedProxies
+}
+
+// NewRateLimiter returns a RateLimiter allowing limit requests per window and client IP
+// with a token bucket stored in memory
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Store: NewMemoryLimiterStore(),
+		Limit: RateLimit{Limit: limit, Window: window},
+	}
+}
+
+// Handler counts the request then calls the next handler
+//
+// Can Panic! if the store fails.
+func (limiter *RateLimiter) Handler(ctx *Context, rw http.ResponseWriter, injector *Injector, next Next) {
+	limit, scope := limiter.Limit, "*"
+	if endpoint := ctx.Endpoint(); endpoint != nil {
+		if routeLimit, ok := endpoint.Attribute(RateLimitAttribute).(RateLimit); ok {
+			limit, scope = routeLimit, endpoint.Name()
+		}
+	}
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		next()
+		return
+	}
+	key := ""
+	if limiter.Key != nil {
+		key = limiter.Key(ctx, injector)
+	}
+	if key == "" {
+		key = "ip:" + limiter.TrustedProxies.ClientIP(ctx.Request)
+	}
+	result := MustWithResult(limiter.Store.Take(scope+"|"+key, limit)).(RateLimitResult)
+	header := rw.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Limit, ceilSeconds(limit.Window)))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}
+	next()
+}
+
+// RateLimitByPrincipal counts requests by principal subject,
+// requests without *Principal are counted by client IP
+func RateLimitByPrincipal(ctx *Context, injector *Injector) string {
+	if principal, err := injector.Resolve(reflect.TypeOf((*Principal)(nil))); err == nil {
+		return "principal:" + principal.(*Principal).Subject
+	}
+	return ""
+}
+
+// RateLimitByHeader returns a RateLimitKey counting requests by the value of a header
+// such as an API key, requests without the header are counted by client IP
+func RateLimitByHeader(name string) RateLimitKey {
+	return func(ctx *Context, injector *Injector) string {
+		if value := ctx.Request.Header.Get(name); value != "" {
+			return "header:" + value
+		}
+		return ""
+	}
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// MemoryLimiterStore stores request counts in memory. Keys are spread
+// over shards with their own lock so concurrent requests rarely wait.
+type MemoryLimiterStore struct {
+	shards []*limiterShard
+}
+
+type limiterShard struct {
+	mutex   sync.Mutex
+	entries map[string]*limiterEntry
+	takes   int
+}
+
+type limiterEntry struct {
+	// tokens of a token bucket or count of the current window
+	value float64
+	// previous is the count of the previous window
+	previous float64
+	// start is the last refill of a token bucket or the start of the current window
+	start   time.Time
+	expires time.Time
+}
+
+// NewMemoryLimiterStore returns a new MemoryLimiterStore
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	store := &MemoryLimiterStore{shards: make([]*limiterShard, 32)}
+	for i := range store.shards {
+		store.shards[i] = &limiterShard{entries: map[string]*limiterEntry{}}
+	}
+	return store
+}
+
+// Take counts a request for key
+func (store *MemoryLimiterStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	shard := store.shards[hash.Sum32()%uint32(len(store.shards))]
+	now := time.Now()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	// remove expired entries from time to time
+	if shard.takes++; shard.takes%1000 == 0 {
+		for k, entry := range shard.entries {
+			if now.After(entry.expires) {
+				delete(shard.entries, k)
+			}
+		}
+	}
+	entry, ok := shard.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &limiterEntry{start: now}
+		if limit.Algorithm == TokenBucket {
+			entry.value = float64(limit.Limit)
+		}
+		shard.entries[key] = entry
+	}
+	if limit.Algorithm == SlidingWindow {
+		return entry.slidingWindow(limit, now), nil
+	}
+	return entry.tokenBucket(limit, now), nil
+}
+
+func (entry *limiterEntry) tokenBucket(limit RateLimit, now time.Time) RateLimitResult {
+	rate := float64(limit.Limit) / float64(limit.Window)
+	entry.value = math.Min(float64(limit.Limit), entry.value+float64(now.Sub(entry.start))*rate)
+	entry.start = now
+	result := RateLimitResult{}
+	if entry.value >= 1 {
+		entry.value--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - entry.value) / rate)
+	}
+	result.Remaining = int(entry.value)
+	result.Reset = time.Duration((float64(limit.Limit) - entry.value) / rate)
+	entry.expires = now.Add(result.Reset)
+	return result
+}
+
+func (entry *limiterEntry) slidingWindow(limit RateLimit, now time.Time) RateLimitResult {
+	if elapsed := now.Sub(entry.start); elapsed >= limit.Window {
+		windows := elapsed / limit.Window
+		entry.previous = 0
+		if windows == 1 {
+			entry.previous = entry.value
+		}
+		entry.value = 0
+		entry.start = entry.start.Add(windows * limit.Window)
+	}
+	// weight of the previous window in the sliding window ending now
+	weight := 1 - float64(now.Sub(entry.start))/float64(limit.Window)
+	count := entry.previous*weight + entry.value
+	result := RateLimitResult{Reset: entry.start.Add(limit.Window).Sub(now)}
+	if count+1 <= float64(limit.Limit) {
+		entry.value++
+		count++
+		result.Allowed = true
+	} else if entry.value+1 > float64(limit.Limit) {
+		// the current window is full whatever the previous window count
+		result.RetryAfter = result.Reset
+	} else {
+		// wait until the weighted previous count leaves room for a request
+		needed := (count + 1 - float64(limit.Limit)) / entry.previous
+		result.RetryAfter = time.Duration(needed * float64(limit.Window))
+	}
+	result.Remaining = int(math.Max(0, float64(limit.Limit)-count))
+	entry.expires = entry.start.Add(2 * limit.Window)
+	return result
+}
@@ -0,0 +1,205 @@
+package micro
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+/**********************************/
+/*            RENDERING           */
+/**********************************/
+
+// Renderer renders named templates
+type Renderer interface {
+	Render(w io.Writer, name string, data interface{}, ctx *Context) error
+}
+
+// TemplateRenderer renders html/template templates loaded from a file system.
+//
+// Templates are named by their path without extension. Templates in the "layouts"
+// directory are layouts, templates in the "partials" directory are partials,
+// other templates are pages. A page is rendered in the layout named Layout,
+// which includes the page with {{template "content" .}} . Partials are included
+// by name, for instance {{template "partials/menu" .}} .
+//
+// The following helpers are available in templates :
+//
+//   - url returns the path of a route given its name and variables : {{url "user" "id" .ID}}
+//   - csrfToken returns the CSRF token of the request
+//   - csrfField returns a hidden input holding the CSRF token, named after the CSRFProtection field
+//   - flashes returns the flash messages of the session of the request
+//
+// Example:
+//
+//    app.Renderer = micro.NewTemplateRenderer(os.DirFS("templates"))
+//    app.Get("/", func(ctx *micro.Context) {
+//        micro.Must(ctx.Render(200, "index", data))
+//    })
+type TemplateRenderer struct {
+	FS fs.FS
+	// Extension is the extension of template files
+	Extension string
+	// Layout is the layout pages are rendered in, pages are rendered alone if empty
+	Layout string
+	// Funcs are functions available in templates
+	Funcs template.FuncMap
+	// Debug reloads templates before each render, so that changes are visible without a restart
+	Debug bool
+	mutex sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// NewTemplateRenderer returns a TemplateRenderer loading .html templates from fsys
+// and rendering pages in the "layouts/main" layout
+func NewTemplateRenderer(fsys fs.FS) *TemplateRenderer {
+	return &TemplateRenderer{FS: fsys, Extension: ".html", Layout: "layouts/main", Funcs: template.FuncMap{}}
+}
+
+// NewTemplateRendererFromDirectory returns a TemplateRenderer loading templates from a directory
+func NewTemplateRendererFromDirectory(directory string) *TemplateRenderer {
+	return NewTemplateRenderer(os.DirFS(directory))
+}
+
+// Load parses the templates, Render loads them if they are not loaded
+func (renderer *TemplateRenderer) Load() error {
+	var shared, pages []string
+	err := fs.WalkDir(renderer.FS, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(file, renderer.Extension) {
+			return err
+		}
+		if strings.HasPrefix(file, "layouts/") || strings.HasPrefix(file, "partials/") {
+			shared = append(shared, file)
+		} else {
+			pages = append(pages, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// helpers are replaced by request bound helpers when rendering
+	base := template.New("").Funcs(templateHelpers(nil)).Funcs(renderer.Funcs)
+	for _, file := range shared {
+		if err = renderer.parse(base, file); err != nil {
+			return err
+		}
+	}
+	loaded := map[string]*template.Template{}
+	for _, file := range pages {
+		page, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if err = renderer.parse(page, file); err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(file, renderer.Extension)
+		if _, err = page.AddParseTree("content", page.Lookup(name).Tree); err != nil {
+			return err
+		}
+		loaded[name] = page
+	}
+	renderer.mutex.Lock()
+	renderer.pages = loaded
+	renderer.mutex.Unlock()
+	return nil
+}
+
+func (renderer *TemplateRenderer) parse(t *template.Template, file string) error {
+	content, err := fs.ReadFile(renderer.FS, file)
+	if err != nil {
+		return err
+	}
+	_, err = t.New(strings.TrimSuffix(file, renderer.Extension)).Parse(string(content))
+	return err
+}
+
+// Render renders the page name with data
+func (renderer *TemplateRenderer) Render(w io.Writer, name string, data interface{}, ctx *Context) error {
+	renderer.mutex.RLock()
+	loaded := renderer.pages != nil
+	renderer.mutex.RUnlock()
+	if !loaded || renderer.Debug {
+		if err := renderer.Load(); err != nil {
+			return err
+		}
+	}
+	renderer.mutex.RLock()
+	name = path.Clean(name)
+	page, ok := renderer.pages[name]
+	renderer.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("template %s not found", name)
+	}
+	page, err := page.Clone()
+	if err != nil {
+		return err
+	}
+	page.Funcs(templateHelpers(ctx))
+	if renderer.Layout != "" && page.Lookup(renderer.Layout) != nil {
+		return page.ExecuteTemplate(w, renderer.Layout, data)
+	}
+	return page.ExecuteTemplate(w, name, data)
+}
+
+// templateHelpers returns the helpers bound to the request of ctx
+func templateHelpers(ctx *Context) template.FuncMap {
+	return template.FuncMap{
+		"url": func(name string, pairs ...interface{}) (string, error) {
+			if ctx == nil || ctx.app == nil {
+				return "", errors.New("url : no application")
+			}
+			values := []string{}
+			for _, pair := range pairs {
+				values = append(values, fmt.Sprint(pair))
+			}
+			if len(values)%2 != 0 {
+				return "", fmt.Errorf("url %s : odd number of arguments", name)
+			}
+			return ctx.app.URL(name, values...)
+		},
+		"csrfToken": func() string {
+			if ctx == nil {
+				return ""
+			}
+			return ctx.CSRFToken()
+		},
+		"csrfField": func() template.HTML {
+			if ctx == nil {
+				return ""
+			}
+			return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+				template.HTMLEscapeString(ctx.CSRFField()), template.HTMLEscapeString(ctx.CSRFToken())))
+		},
+		"flashes": func() []string {
+			if ctx == nil || ctx.Session() == nil {
+				return nil
+			}
+			return ctx.Session().Flashes()
+		},
+	}
+}
+
+// Render renders the template name of the application Renderer with data,
+// then writes it with the status code. Nothing is written if rendering fails.
+func (ctx *Context) Render(code int, name string, data interface{}) error {
+	if ctx.app == nil || ctx.app.Renderer == nil {
+		return errors.New("no renderer, set Micro.Renderer")
+	}
+	buffer := new(bytes.Buffer)
+	if err := ctx.app.Renderer.Render(buffer, name, data, ctx); err != nil {
+		return err
+	}
+	ctx.Response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Response.WriteHeader(code)
+	_, err := buffer.WriteTo(ctx.Response)
+	return err
+}
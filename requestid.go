@@ -0,0 +1,105 @@
+package micro
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+/**********************************/
+/*           REQUEST ID           */
+/**********************************/
+
+// RequestIDVar is the key of the request ID in Context.Vars
+const RequestIDVar = "micro.request_id"
+
+// RequestID identifies a request, it is registered in the request injector
+// by RequestIDMiddleware.
+type RequestID string
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	traceKey
+)
+
+// validRequestID prevents clients from injecting arbitrary data in logs
+var validRequestID = regexp.MustCompile(`^[\w\-.:]{1,128}$`)
+
+// validTraceparent matches W3C trace context traceparent headers
+var validTraceparent = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// RequestIDMiddleware is a middleware that reads the request ID from a request header
+// or generates one. The request ID is stored in Context.Vars, registered in the request
+// injector, attached to the request context.Context and echoed back in the response header.
+// The W3C trace context headers of the request are also attached to the request context.Context,
+// so that the HTTP client of the application forwards them with the request ID.
+//
+// Example:
+//
+//    app.Use("/", micro.NewRequestIDMiddleware().Handler)
+//    app.DeclareRequestService(micro.RequestID(""))
+type RequestIDMiddleware struct {
+	// Header is the request and response header holding the request ID
+	Header string
+	// Generate returns a new request ID
+	Generate func() string
+}
+
+// NewRequestIDMiddleware returns a RequestIDMiddleware using the X-Request-ID header
+// and generating random IDs
+func NewRequestIDMiddleware() *RequestIDMiddleware {
+	return &RequestIDMiddleware{Header: "X-Request-ID", Generate: GenerateRequestID}
+}
+
+// Handler sets the request ID then calls the next handler
+func (middleware *RequestIDMiddleware) Handler(ctx *Context, injector *Injector, next Next) {
+	id := ctx.Request.Header.Get(middleware.Header)
+	if !validRequestID.MatchString(id) {
+		id = middleware.Generate()
+	}
+	ctx.Vars[RequestIDVar] = id
+	requestContext := context.WithValue(ctx.Request.Context(), requestIDKey, id)
+	if traceparent := ctx.Request.Header.Get("Traceparent"); validTraceparent.MatchString(traceparent) {
+		trace := http.Header{"Traceparent": {traceparent}}
+		if tracestate := ctx.Request.Header.Get("Tracestate"); tracestate != "" {
+			trace.Set("Tracestate", tracestate)
+		}
+		requestContext = context.WithValue(requestContext, traceKey, trace)
+	}
+	ctx.Request = ctx.Request.WithContext(requestContext)
+	injector.Register(ctx.Request)
+	injector.Register(RequestID(id))
+	ctx.Response.Header().Set(middleware.Header, id)
+	next()
+}
+
+// GenerateRequestID returns a random 128 bits hexadecimal request ID
+func GenerateRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestIDFromContext returns the request ID attached to a context.Context,
+// forward it when calling other services.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// TraceHeadersFromContext returns the W3C trace context headers attached to a context.Context
+// by RequestIDMiddleware, or nil
+func TraceHeadersFromContext(ctx context.Context) http.Header {
+	trace, _ := ctx.Value(traceKey).(http.Header)
+	return trace
+}
+
+// RequestID returns the request ID set by RequestIDMiddleware or an empty string
+func (ctx *Context) RequestID() string {
+	id, _ := ctx.Vars[RequestIDVar].(string)
+	return id
+}
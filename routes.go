@@ -0,0 +1,160 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+/**********************************/
+/*           ROUTE TABLE          */
+/**********************************/
+
+// RouteInfo describes a frozen route
+type RouteInfo struct {
+	Name        string                 `json:"name"`
+	Methods     []string               `json:"methods"`
+	Host        string                 `json:"host,omitempty"`
+	Path        string                 `json:"path"`
+	Pattern     string                 `json:"pattern"`
+	Params      []string               `json:"params"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	Passthrough bool                   `json:"passthrough"`
+}
+
+// NewRouteInfo returns the description of a route. The description holds copies
+// of the methods, params and attributes of the route, changing them does not change the route.
+func NewRouteInfo(route *Route) RouteInfo {
+	attributes := make(map[string]interface{}, len(route.Attributes()))
+	for name, value := range route.Attributes() {
+		attributes[name] = value
+	}
+	info := RouteInfo{
+		Name:        route.Name(),
+		Methods:     append([]string(nil), route.Methods()...),
+		Host:        route.HostPattern(),
+		Path:        route.Path(),
+		Params:      append([]string(nil), route.Params()...),
+		Attributes:  attributes,
+		Passthrough: route.IsPassthrough(),
+	}
+	if route.Pattern() != nil {
+		info.Pattern = route.Pattern().String()
+	}
+	return info
+}
+
+// RouteTable returns the description of every route of the application,
+// in the order they are matched against a request.
+// The application is booted if it is not, use Boot to get boot errors.
+func (e *Micro) RouteTable() []RouteInfo {
+	e.Boot()
+	table := []RouteInfo{}
+	for _, route := range e.ControllerCollection.Routes {
+		table = append(table, NewRouteInfo(route))
+	}
+	return table
+}
+
+// PrintRoutes writes the route table of the application to w as a text table
+func (e *Micro) PrintRoutes(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMETHODS\tHOST\tPATH\tPATTERN\tPARAMS\tPASSTHROUGH\tATTRIBUTES")
+	for _, info := range e.RouteTable() {
+		methods := "*"
+		if len(info.Methods) > 0 {
+			methods = strings.Join(info.Methods, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%v\n", info.Name, methods, info.Host, info.Path,
+			info.Pattern, strings.Join(info.Params, ","), info.Passthrough, info.Attributes)
+	}
+	return tw.Flush()
+}
+
+// PrintRoutesJSON writes the route table of the application to w as JSON.
+// Attribute values that cannot be encoded, such as functions, are formatted with fmt.Sprint .
+func (e *Micro) PrintRoutesJSON(w io.Writer) error {
+	table := e.RouteTable()
+	for i, info := range table {
+		attributes := map[string]interface{}{}
+		for name, value := range info.Attributes {
+			if _, err := json.Marshal(value); err != nil {
+				value = fmt.Sprint(value)
+			}
+			attributes[name] = value
+		}
+		table[i].Attributes = attributes
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(table)
+}
+
+/**********************************/
+/*         REVERSE ROUTING        */
+/**********************************/
+
+// URL returns the path of the route named name, see Route.URL .
+// The application is booted if it is not.
+func (e *Micro) URL(name string, pairs ...string) (string, error) {
+	e.Boot()
+	for _, route := range e.ControllerCollection.Routes {
+		if route.Name() == name {
+			return route.URL(pairs...)
+		}
+	}
+	return "", fmt.Errorf("route %s not found", name)
+}
+
+// URL returns the path of a frozen route, its variables are replaced by values
+// given as names followed by values. Variables of regexp groups are named by their position.
+// Values must match the assertion of their variable, optional variables can be omitted.
+//
+// Example:
+//
+//    route.URL("category", "books", "productId", "42") // /catalog/books/42
+//
+// Can Panic! if the number of pairs is odd.
+func (r *Route) URL(pairs ...string) (string, error) {
+	values := mustBePairs(pairs)
+	var err error
+	i := 0
+	path := regexp.MustCompile(Pattern).ReplaceAllStringFunc(r.path, func(match string) string {
+		if i >= len(r.params) {
+			return match
+		}
+		param := r.params[i]
+		i++
+		value, ok := values[param]
+		if !ok {
+			if !strings.HasSuffix(match, "?") && err == nil {
+				err = fmt.Errorf("route %s : missing value for %s", r.Name(), param)
+			}
+			return ""
+		}
+		pattern := r.assertions[param]
+		if match[0] == '(' {
+			pattern = match
+		} else if pattern == "" {
+			pattern = DefaultParamPattern
+		}
+		if matched, _ := regexp.MatchString("^(?:"+pattern+")$", value); !matched && err == nil {
+			err = fmt.Errorf("route %s : value %q of %s does not match %s", r.Name(), value, param, pattern)
+		}
+		return url.PathEscape(value)
+	})
+	if err != nil {
+		return "", err
+	}
+	// remaining ? come from collection prefixes
+	path = strings.Replace(path, "?", "", -1)
+	path = regexp.MustCompile("/+").ReplaceAllString("/"+path, "/")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path, nil
+}
@@ -0,0 +1,479 @@
+package micro
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/**********************************/
+/*             SESSION            */
+/**********************************/
+
+// SessionVar is the key of the *Session in Context.Vars
+const SessionVar = "micro.session"
+
+// Session holds data across the requests of a client.
+// Values are encoded with encoding/gob, custom types must be registered with gob.Register .
+// A Session is not safe for concurrent use.
+type Session struct {
+	data        sessionData
+	token       string
+	isNew       bool
+	modified    bool
+	regenerated bool
+	destroyed   bool
+}
+
+type sessionData struct {
+	ID       string
+	Values   map[string]interface{}
+	Flashes  []string
+	Created  time.Time
+	Accessed time.Time
+}
+
+func newSession() *Session {
+	now := time.Now()
+	return &Session{
+		data: sessionData{
+			ID:       newSessionID(),
+			Values:   map[string]interface{}{},
+			Created:  now,
+			Accessed: now,
+		},
+		isNew: true,
+	}
+}
+
+// ID returns the session ID
+func (session *Session) ID() string {
+	return session.data.ID
+}
+
+// IsNew returns true if the session was created during the request
+func (session *Session) IsNew() bool {
+	return session.isNew
+}
+
+// Get returns a session value
+func (session *Session) Get(key string) interface{} {
+	return session.data.Values[key]
+}
+
+// Set sets a session value
+func (session *Session) Set(key string, value interface{}) {
+	session.data.Values[key] = value
+	session.modified = true
+}
+
+// Delete removes a session value
+func (session *Session) Delete(key string) {
+	delete(session.data.Values, key)
+	session.modified = true
+}
+
+// AddFlash adds a message that will be available until Flashes is called
+func (session *Session) AddFlash(message string) {
+	session.data.Flashes = append(session.data.Flashes, message)
+	session.modified = true
+}
+
+// Flashes returns flash messages and removes them from the session
+func (session *Session) Flashes() []string {
+	flashes := session.data.Flashes
+	if len(flashes) > 0 {
+		session.data.Flashes = nil
+		session.modified = true
+	}
+	return flashes
+}
+
+// Regenerate gives the session a new ID while keeping its values,
+// call it when a user logs in to prevent session fixation.
+func (session *Session) Regenerate() {
+	session.data.ID = newSessionID()
+	session.regenerated = true
+	session.modified = true
+}
+
+// Destroy removes the session from the store and the client
+func (session *Session) Destroy() {
+	session.destroyed = true
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/**********************************/
+/*         SESSION MANAGER        */
+/**********************************/
+
+// Session returns the session of the request,
+// or nil if there is no SessionManager middleware
+func (ctx *Context) Session() *Session {
+	session, _ := ctx.Vars[SessionVar].(*Session)
+	return session
+}
+
+// SessionManager is a middleware that loads the session of a request and
+// registers it in the request injector as a *Session.
+// The session is saved before the response header is written.
+//
+// Example:
+//
+//    store := micro.MustWithResult(micro.NewCookieSessionStore(key)).(micro.SessionStore)
+//    app.Use("/", micro.NewSessionManager(store).Handler)
+//    app.DeclareRequestService((*micro.Session)(nil))
+type SessionManager struct {
+	// Store stores session data
+	Store SessionStore
+	// Cookie is the template of session cookies : name, path, domain, Secure, HttpOnly and SameSite
+	Cookie http.Cookie
+	// IdleTimeout expires sessions not used for that duration, 0 disables idle expiry
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions created that duration ago, 0 disables absolute expiry
+	AbsoluteTimeout time.Duration
+}
+
+// NewSessionManager returns a new SessionManager with secure defaults :
+// cookies are HttpOnly, Secure and SameSite=Lax, sessions expire after 30 minutes
+// of inactivity and 24 hours after their creation.
+func NewSessionManager(store SessionStore) *SessionManager {
+	return &SessionManager{
+		Store: store,
+		Cookie: http.Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+	}
+}
+
+// Handler loads the session, calls the next handler and saves the session
+//
+// Can Panic! if the store fails.
+func (manager *SessionManager) Handler(ctx *Context, rw *ResponseWriterWithCode, injector *Injector, next Next) {
+	session := MustWithResult(manager.Load(ctx.Request)).(*Session)
+	injector.Register(session)
+	ctx.Vars[SessionVar] = session
+	saved := false
+	save := func() {
+		if !saved {
+			saved = true
+			Must(manager.Save(rw, session))
+		}
+	}
+	rw.BeforeWriteHeader(save)
+	next()
+	if !rw.HeaderWritten() {
+		save()
+	}
+}
+
+// Load returns the session of a request, or a new session if the request has no valid session
+func (manager *SessionManager) Load(request *http.Request) (*Session, error) {
+	cookie, err := request.Cookie(manager.Cookie.Name)
+	if err != nil {
+		return newSession(), nil
+	}
+	data, err := manager.Store.Load(cookie.Value)
+	if err != nil || data == nil {
+		return newSession(), err
+	}
+	session := &Session{token: cookie.Value}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&session.data); err != nil {
+		return newSession(), nil
+	}
+	if session.data.Values == nil {
+		session.data.Values = map[string]interface{}{}
+	}
+	now := time.Now()
+	if (manager.IdleTimeout > 0 && now.Sub(session.data.Accessed) > manager.IdleTimeout) ||
+		(manager.AbsoluteTimeout > 0 && now.Sub(session.data.Created) > manager.AbsoluteTimeout) {
+		manager.Store.Delete(cookie.Value)
+		return newSession(), nil
+	}
+	session.data.Accessed = now
+	return session, nil
+}
+
+// Save stores the session and sets the session cookie.
+// Unmodified new sessions are not saved.
+func (manager *SessionManager) Save(rw http.ResponseWriter, session *Session) error {
+	cookie := manager.Cookie
+	if session.destroyed {
+		if session.token != "" {
+			if err := manager.Store.Delete(session.token); err != nil {
+				return err
+			}
+		}
+		if !session.isNew {
+			cookie.MaxAge = -1
+			http.SetCookie(rw, &cookie)
+		}
+		return nil
+	}
+	// the access time must be saved for idle expiry
+	if !session.modified && (session.isNew || manager.IdleTimeout == 0) {
+		return nil
+	}
+	if session.regenerated && session.token != "" {
+		if err := manager.Store.Delete(session.token); err != nil {
+			return err
+		}
+	}
+	buffer := new(bytes.Buffer)
+	if err := gob.NewEncoder(buffer).Encode(session.data); err != nil {
+		return err
+	}
+	lifetime := manager.IdleTimeout
+	if manager.AbsoluteTimeout > 0 {
+		remaining := time.Until(session.data.Created.Add(manager.AbsoluteTimeout))
+		if lifetime == 0 || remaining < lifetime {
+			lifetime = remaining
+		}
+		cookie.Expires = session.data.Created.Add(manager.AbsoluteTimeout)
+	}
+	token, err := manager.Store.Save(session.ID(), buffer.Bytes(), lifetime)
+	if err != nil {
+		return err
+	}
+	cookie.Value = token
+	http.SetCookie(rw, &cookie)
+	return nil
+}
+
+/**********************************/
+/*         SESSION STORES         */
+/**********************************/
+
+// SessionStore stores session data. The token is the value of the session cookie.
+type SessionStore interface {
+	// Load returns the data of a session or nil if the session does not exist or has expired
+	Load(token string) ([]byte, error)
+	// Save stores the data of a session for lifetime, 0 meaning forever, and returns its token
+	Save(id string, data []byte, lifetime time.Duration) (token string, err error)
+	// Delete removes a session
+	Delete(token string) error
+}
+
+// CookieSessionStore stores session data in the session cookie itself,
+// encrypted and authenticated with AES-GCM.
+type CookieSessionStore struct {
+	ciphers []cipher.AEAD
+}
+
+// NewCookieSessionStore returns a new CookieSessionStore given AES keys of 16, 24 or 32 bytes.
+// The first key encrypts, all keys decrypt so keys can be rotated.
+func NewCookieSessionStore(keys ...[]byte) (*CookieSessionStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+	store := &CookieSessionStore{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		store.ciphers = append(store.ciphers, aead)
+	}
+	return store, nil
+}
+
+// Load decrypts the token
+func (store *CookieSessionStore) Load(token string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, nil
+	}
+	for _, aead := range store.ciphers {
+		if len(sealed) < aead.NonceSize() {
+			return nil, nil
+		}
+		if data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil); err == nil {
+			return data, nil
+		}
+	}
+	return nil, nil
+}
+
+// Save encrypts data, the lifetime is enforced by the session manager
+func (store *CookieSessionStore) Save(id string, data []byte, lifetime time.Duration) (string, error) {
+	aead := store.ciphers[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil))
+	// browsers reject cookies larger than 4KB
+	if len(token) > 4000 {
+		return "", fmt.Errorf("session is too large to be stored in a cookie : %d bytes", len(token))
+	}
+	return token, nil
+}
+
+// Delete does nothing, the session manager expires the cookie
+func (store *CookieSessionStore) Delete(token string) error {
+	return nil
+}
+
+// MemorySessionStore stores session data in memory
+type MemorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]memorySession
+	saves    int
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore returns a new MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]memorySession{}}
+}
+
+// Load returns the data of a session
+func (store *MemorySessionStore) Load(token string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	session, ok := store.sessions[token]
+	if !ok || session.expired() {
+		delete(store.sessions, token)
+		return nil, nil
+	}
+	return session.data, nil
+}
+
+// Save stores the data of a session, the token is the session ID
+func (store *MemorySessionStore) Save(id string, data []byte, lifetime time.Duration) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	session := memorySession{data: data}
+	if lifetime > 0 {
+		session.expires = time.Now().Add(lifetime)
+	}
+	store.sessions[id] = session
+	// remove expired sessions from time to time
+	if store.saves++; store.saves%1000 == 0 {
+		for token, session := range store.sessions {
+			if session.expired() {
+				delete(store.sessions, token)
+			}
+		}
+	}
+	return id, nil
+}
+
+// Delete removes a session
+func (store *MemorySessionStore) Delete(token string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, token)
+	return nil
+}
+
+func (session memorySession) expired() bool {
+	return !session.expires.IsZero() && time.Now().After(session.expires)
+}
+
+// FileSessionStore stores session data in a directory, one file per session
+type FileSessionStore struct {
+	directory string
+}
+
+// validSessionID prevents tokens from being used as arbitrary paths
+var validSessionID = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// NewFileSessionStore returns a new FileSessionStore, the directory is created if needed
+func NewFileSessionStore(directory string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{directory}, nil
+}
+
+// Load returns the data of a session
+func (store *FileSessionStore) Load(token string) ([]byte, error) {
+	if !validSessionID.MatchString(token) {
+		return nil, nil
+	}
+	content, err := os.ReadFile(store.path(token))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the first line is the expiry unix timestamp
+	line, data, found := bytes.Cut(content, []byte("\n"))
+	if !found {
+		return nil, nil
+	}
+	if expires, err := strconv.ParseInt(string(line), 10, 64); err != nil || (expires > 0 && time.Now().Unix() > expires) {
+		return nil, store.Delete(token)
+	}
+	return data, nil
+}
+
+// Save writes the data of a session to a file, the token is the session ID
+func (store *FileSessionStore) Save(id string, data []byte, lifetime time.Duration) (string, error) {
+	var expires int64
+	if lifetime > 0 {
+		expires = time.Now().Add(lifetime).Unix()
+	}
+	file, err := os.CreateTemp(store.directory, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err = fmt.Fprintf(file, "%d\n%s", expires, data); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err = file.Close(); err != nil {
+		return "", err
+	}
+	return id, os.Rename(file.Name(), store.path(id))
+}
+
+// Delete removes the file of a session
+func (store *FileSessionStore) Delete(token string) error {
+	if !validSessionID.MatchString(token) {
+		return nil
+	}
+	if err := os.Remove(store.path(token)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *FileSessionStore) path(id string) string {
+	return filepath.Join(store.directory, id+".session")
+}
@@ -0,0 +1,243 @@
+package micro
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+)
+
+/**********************************/
+/*             UPLOADS            */
+/**********************************/
+
+// UploadOptionsAttribute is the route attribute holding the UploadOptions of a route,
+// it replaces DefaultUploadOptions
+const UploadOptionsAttribute = "upload.options"
+
+var (
+	// ErrFileTooLarge is returned when an uploaded file is larger than UploadOptions.MaxFileSize
+	ErrFileTooLarge = errors.New("uploaded file too large")
+	// ErrTooManyFiles is returned when a request has more files than UploadOptions.MaxFiles
+	ErrTooManyFiles = errors.New("too many uploaded files")
+	// ErrValuesTooLarge is returned when the non file fields of a request are larger than UploadOptions.MaxValueBytes
+	ErrValuesTooLarge = errors.New("multipart values too large")
+	// ErrUnsupportedFileType is returned when the sniffed type of an uploaded file is not allowed
+	ErrUnsupportedFileType = errors.New("unsupported uploaded file type")
+)
+
+// UploadOptions are the limits of multipart uploads
+type UploadOptions struct {
+	// MaxFileSize is the maximum size of a file, 0 means no limit
+	MaxFileSize int64
+	// MaxFiles is the maximum number of files of a request, 0 means no limit
+	MaxFiles int
+	// MaxValueBytes is the maximum size of all the non file fields of a request, 0 means no limit
+	MaxValueBytes int64
+	// AllowedTypes are the media types allowed, type/* wildcards are supported.
+	// The type of a file is sniffed from its content, the client type is ignored.
+	// An empty list allows all types.
+	AllowedTypes []string
+	// Directory is where temporary files are written, the default temporary directory if empty
+	Directory string
+}
+
+// DefaultUploadOptions are the UploadOptions of routes without an UploadOptionsAttribute attribute
+var DefaultUploadOptions = UploadOptions{
+	MaxFileSize:   32 << 20,
+	MaxFiles:      10,
+	MaxValueBytes: 1 << 20,
+}
+
+// UploadedFile is a file of a multipart request streamed to a temporary file.
+// Temporary files are removed when the request ends.
+type UploadedFile struct {
+	Field    string
+	Filename string
+	Header   textproto.MIMEHeader
+	// ContentType is the type sniffed from the content of the file
+	ContentType string
+	Size        int64
+	// SHA256 is the hex encoded SHA-256 checksum of the file
+	SHA256 string
+	// Path is the path of the file on disk
+	Path string
+}
+
+// Open opens the file for reading
+func (file *UploadedFile) Open() (*os.File, error) {
+	return os.Open(file.Path)
+}
+
+// Files returns the files uploaded in a multipart field. The whole multipart body is read
+// the first time, files are streamed to disk so their size is not bounded by memory.
+// Other fields are available in Request.MultipartForm and Request.PostForm .
+// If a limit of the UploadOptions is exceeded, the 413 status is set or the 415 status
+// if a file type is not allowed, calling Next then executes the error handler.
+func (ctx *Context) Files(field string) ([]*UploadedFile, error) {
+	if err := ctx.parseUploads(); err != nil {
+		return nil, err
+	}
+	return ctx.uploads[field], nil
+}
+
+// SaveFile moves the first file uploaded in a multipart field to dst
+func (ctx *Context) SaveFile(field string, dst string) (*UploadedFile, error) {
+	files, err := ctx.Files(field)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, http.ErrMissingFile
+	}
+	file := files[0]
+	if err = os.Rename(file.Path, dst); err != nil {
+		// dst may be on another device, copy the file instead
+		if err = copyFile(file.Path, dst); err != nil {
+			return nil, err
+		}
+		os.Remove(file.Path)
+	}
+	file.Path = dst
+	return file, nil
+}
+
+// cleanup removes the temporary files of the request once it ends
+func (ctx *Context) cleanup() {
+	for _, function := range ctx.cleanups {
+		function()
+	}
+	ctx.cleanups = nil
+}
+
+func (ctx *Context) parseUploads() error {
+	if ctx.uploads != nil {
+		return ctx.uploadErr
+	}
+	ctx.uploads = map[string][]*UploadedFile{}
+	options := DefaultUploadOptions
+	if endpoint := ctx.Endpoint(); endpoint != nil {
+		if routeOptions, ok := endpoint.Attribute(UploadOptionsAttribute).(UploadOptions); ok {
+			options = routeOptions
+		}
+	}
+	ctx.uploadErr = ctx.readParts(options)
+	if ctx.uploadErr != nil {
+		switch {
+		case errors.Is(ctx.uploadErr, ErrFileTooLarge), errors.Is(ctx.uploadErr, ErrTooManyFiles), errors.Is(ctx.uploadErr, ErrValuesTooLarge):
+			ctx.Response.WriteHeader(http.StatusRequestEntityTooLarge)
+		case errors.Is(ctx.uploadErr, ErrUnsupportedFileType):
+			ctx.Response.WriteHeader(http.StatusUnsupportedMediaType)
+		default:
+			ctx.uploadErr = ctx.bodyError(ctx.uploadErr)
+		}
+	}
+	return ctx.uploadErr
+}
+
+func (ctx *Context) readParts(options UploadOptions) error {
+	reader, err := ctx.Request.MultipartReader()
+	if err != nil {
+		return err
+	}
+	values, valueBytes, files := map[string][]string{}, int64(0), 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if part.FileName() == "" {
+			var content io.Reader = part
+			if options.MaxValueBytes > 0 {
+				content = io.LimitReader(part, options.MaxValueBytes-valueBytes+1)
+			}
+			value, err := io.ReadAll(content)
+			if err != nil {
+				return err
+			}
+			if valueBytes += int64(len(value)); options.MaxValueBytes > 0 && valueBytes > options.MaxValueBytes {
+				return ErrValuesTooLarge
+			}
+			values[part.FormName()] = append(values[part.FormName()], string(value))
+			continue
+		}
+		if files++; options.MaxFiles > 0 && files > options.MaxFiles {
+			return ErrTooManyFiles
+		}
+		file, err := ctx.saveUpload(part, options)
+		if err != nil {
+			return err
+		}
+		ctx.uploads[file.Field] = append(ctx.uploads[file.Field], file)
+	}
+	ctx.Request.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+	ctx.Request.PostForm = values
+	return nil
+}
+
+// saveUpload streams a part to a temporary file, sniffing its type and computing its checksum
+func (ctx *Context) saveUpload(part *multipart.Part, options UploadOptions) (*UploadedFile, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if mediaType, _, _ := mime.ParseMediaType(contentType); len(options.AllowedTypes) > 0 && !matchMediaType(options.AllowedTypes, mediaType) {
+		return nil, ErrUnsupportedFileType
+	}
+	temp, err := os.CreateTemp(options.Directory, "micro-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	ctx.cleanups = append(ctx.cleanups, func() { os.Remove(temp.Name()) })
+	defer temp.Close()
+	hash := sha256.New()
+	var content io.Reader = io.MultiReader(bytes.NewReader(head), part)
+	if options.MaxFileSize > 0 {
+		content = io.LimitReader(content, options.MaxFileSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(temp, hash), content)
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxFileSize > 0 && size > options.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	return &UploadedFile{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		Header:      part.Header,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Path:        temp.Name(),
+	}, temp.Close()
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}